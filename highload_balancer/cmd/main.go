@@ -10,10 +10,14 @@ import (
 	"time"
 
 	_ "github.com/lib/pq"
+	"github.com/redis/go-redis/v9"
+	"github.com/se1y4/highload-balancer/internal/adaptive"
 	"github.com/se1y4/highload-balancer/internal/balancer"
+	"github.com/se1y4/highload-balancer/internal/cluster"
 	"github.com/se1y4/highload-balancer/internal/config"
 	"github.com/se1y4/highload-balancer/internal/ratelimiter"
 	"github.com/se1y4/highload-balancer/internal/server"
+	"github.com/se1y4/highload-balancer/utils"
 )
 
 func main() {
@@ -22,15 +26,47 @@ func main() {
 		log.Fatalf("Error loading config: %v", err)
 	}
 
-	pgStorage, err := ratelimiter.NewPostgresStorage(cfg.Postgres.ConnString)
-	if err != nil {
-		log.Fatalf("Failed to init PostgreSQL: %v", err)
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		runMigrate(cfg, os.Args[2:])
+		return
 	}
-	defer pgStorage.Close()
 
-	if err := pgStorage.InitSchema(); err != nil {
-		log.Fatalf("Failed to init schema: %v", err)
+	var (
+		storage   ratelimiter.ClientStorage
+		pgStorage *ratelimiter.PostgresStorage
+	)
+	switch cfg.Storage.Driver {
+	case "postgres", "":
+		pgStorage, err = ratelimiter.NewPostgresStorage(&ratelimiter.PostgresConfig{
+			ConnString:      cfg.Postgres.ConnString,
+			QueryTimeout:    cfg.Postgres.QueryTimeout,
+			MaxOpenConns:    cfg.Postgres.MaxOpenConns,
+			MaxIdleConns:    cfg.Postgres.MaxIdleConns,
+			ConnMaxLifetime: cfg.Postgres.ConnMaxLifetime,
+		})
+		if err != nil {
+			log.Fatalf("Failed to init PostgreSQL: %v", err)
+		}
+		storage = pgStorage
+
+		if pgStorage.Healthy() {
+			if err := pgStorage.InitSchema(); err != nil {
+				log.Fatalf("Failed to init schema: %v", err)
+			}
+		} else {
+			log.Printf("PostgreSQL is unavailable, starting from the last snapshot: %s", pgStorage.Status().LastError)
+		}
+	case "bolt":
+		storage, err = ratelimiter.NewBoltStorage(cfg.Storage.Path)
+	case "file":
+		storage, err = ratelimiter.NewFileStorage(cfg.Storage.Path)
+	default:
+		log.Fatalf("Unknown storage driver %s", cfg.Storage.Driver)
 	}
+	if err != nil {
+		log.Fatalf("Failed to open %s storage: %v", cfg.Storage.Driver, err)
+	}
+	defer storage.Close()
 
 	strategy := balancer.NewStrategy(balancer.StrategyType(cfg.Balancer.Strategy))
 	lb := balancer.NewLoadBalancer(
@@ -41,15 +77,185 @@ func main() {
 		},
 	)
 
+	rlConfig := &ratelimiter.Config{
+		Algorithm:  ratelimiter.AlgorithmType(cfg.RateLimiter.Algorithm),
+		MaxBuckets: cfg.RateLimiter.MaxBuckets,
+		IdleTTL:    cfg.RateLimiter.IdleTTL,
+		Shards:     cfg.RateLimiter.Shards,
+
+		CostHeader:    cfg.RateLimiter.CostHeader,
+		BodyCostBytes: cfg.RateLimiter.BodyCostBytes,
+	}
+	for _, route := range cfg.RateLimiter.Routes {
+		rlConfig.Routes = append(rlConfig.Routes, ratelimiter.RouteRule{
+			Name:       route.Name,
+			Method:     route.Method,
+			Host:       route.Host,
+			PathPrefix: route.PathPrefix,
+			Capacity:   route.Capacity,
+			RatePerSec: route.RatePerSec,
+			Algorithm:  ratelimiter.AlgorithmType(route.Algorithm),
+			Cost:       route.Cost,
+		})
+	}
+	if err := ratelimiter.ValidateRouteRules(rlConfig.Routes); err != nil {
+		log.Fatalf("Invalid rate limiter routes: %v", err)
+	}
+	if !rlConfig.Algorithm.Valid() {
+		log.Fatalf("Invalid rate_limiter.algorithm %q", cfg.RateLimiter.Algorithm)
+	}
+	if cfg.RateLimiter.DefaultCapacity <= 0 || cfg.RateLimiter.DefaultRate < 0 {
+		log.Fatalf("rate_limiter.default_capacity must be positive and default_rate not negative")
+	}
+	trustedProxies, err := utils.ParseTrustedProxies(cfg.Server.TrustedProxies)
+	if err != nil {
+		log.Fatalf("Invalid server.trusted_proxies: %v", err)
+	}
+
+	switch cfg.RateLimiter.Backend {
+	case "redis":
+		redisStore := ratelimiter.NewRedisStore(redis.NewClient(&redis.Options{
+			Addr:     cfg.Redis.Addr,
+			Password: cfg.Redis.Password,
+			DB:       cfg.Redis.DB,
+		}), cfg.Redis.KeyPrefix)
+		defer redisStore.Close()
+
+		rlConfig.Shared = redisStore
+		rlConfig.SharedTimeout = cfg.Redis.Timeout
+		rlConfig.FallbackRetry = cfg.Redis.FallbackRetry
+	case "postgres":
+		if pgStorage == nil {
+			log.Fatalf("The postgres rate limiter backend requires the postgres storage driver")
+		}
+		syncStore := ratelimiter.NewPostgresSyncStore(
+			pgStorage,
+			cfg.RateLimiter.SyncPeriod,
+			cfg.RateLimiter.MaxOvershoot,
+		)
+		defer syncStore.Close()
+
+		rlConfig.Shared = syncStore
+	case "local", "":
+	default:
+		log.Printf("Unknown rate limiter backend %s, defaulting to local", cfg.RateLimiter.Backend)
+	}
+
 	rl := ratelimiter.NewRateLimiter(
 		cfg.RateLimiter.DefaultCapacity,
 		cfg.RateLimiter.DefaultRate,
-		cfg.RateLimiter.RefillInterval,
+		rlConfig,
 	)
-	defer rl.Stop()
 
-	clientManager := ratelimiter.NewClientManager(pgStorage)
-	srv := server.NewServer(lb, rl, clientManager)
+	if len(cfg.Cluster.Peers) > 0 {
+		membership, err := cluster.NewMembership(&cluster.Config{
+			NodeID:            cfg.Cluster.NodeID,
+			Bind:              cfg.Cluster.Bind,
+			Peers:             cfg.Cluster.Peers,
+			HeartbeatInterval: cfg.Cluster.HeartbeatInterval,
+			PeerTimeout:       cfg.Cluster.PeerTimeout,
+		})
+		if err != nil {
+			log.Fatalf("Failed to start cluster membership: %v", err)
+		}
+		membership.OnChange(rl.SetPeers)
+		membership.Start()
+		defer membership.Stop()
+	}
+
+	quotaLocation, err := time.LoadLocation(cfg.Quota.Timezone)
+	if err != nil {
+		log.Fatalf("Invalid quota timezone: %v", err)
+	}
+	usageStorage, _ := storage.(ratelimiter.UsageStorage)
+	quotas := ratelimiter.NewQuotaTracker(usageStorage, quotaLocation, cfg.Quota.FlushInterval)
+	defer quotas.Stop()
+
+	overrideLocation, err := time.LoadLocation(cfg.Overrides.Timezone)
+	if err != nil {
+		log.Fatalf("Invalid overrides timezone: %v", err)
+	}
+	aclStorage, ok := storage.(ratelimiter.ACLStorage)
+	if !ok {
+		log.Fatalf("Invalid config: storage driver %q does not support ACL", cfg.Storage.Driver)
+	}
+	acl := ratelimiter.NewACL(aclStorage)
+
+	clientManager := ratelimiter.NewClientManager(storage, rl)
+	clientManager.UseACL(acl)
+	if pgStorage != nil {
+		clientManager.UseSnapshot(cfg.Postgres.SnapshotPath)
+	}
+	clientManager.StartOverrides(overrideLocation, cfg.Overrides.CheckInterval)
+	clientManager.StartSync(cfg.Postgres.ResyncInterval)
+	defer clientManager.Stop()
+
+	var adaptiveLimiter *adaptive.Limiter
+	if cfg.Adaptive.Enabled {
+		adaptiveLimiter = adaptive.NewLimiter(&adaptive.Config{
+			Algorithm:    adaptive.AlgorithmType(cfg.Adaptive.Algorithm),
+			InitialLimit: cfg.Adaptive.InitialLimit,
+			MinLimit:     cfg.Adaptive.MinLimit,
+			MaxLimit:     cfg.Adaptive.MaxLimit,
+			Threshold:    cfg.Adaptive.Threshold,
+			Backoff:      cfg.Adaptive.Backoff,
+			Smoothing:    cfg.Adaptive.Smoothing,
+			Window:       cfg.Adaptive.Window,
+		})
+	}
+
+	var shedder *adaptive.Shedder
+	if cfg.Shedding.Enabled {
+		var capacity func() int
+		if adaptiveLimiter != nil {
+			capacity = adaptiveLimiter.Limit
+		}
+		shedder = adaptive.NewShedder(&adaptive.ShedderConfig{
+			MaxInFlight:   cfg.Shedding.MaxInFlight,
+			MaxPriority:   cfg.Shedding.MaxPriority,
+			HighWatermark: cfg.Shedding.HighWatermark,
+			LowWatermark:  cfg.Shedding.LowWatermark,
+			Interval:      cfg.Shedding.Interval,
+			Cooldown:      cfg.Shedding.Cooldown,
+		}, capacity)
+		shedder.Start()
+		defer shedder.Stop()
+	}
+
+	if pgStorage != nil {
+		stopMonitor := make(chan struct{})
+		defer close(stopMonitor)
+		go pgStorage.Monitor(cfg.Postgres.HealthInterval, cfg.Postgres.MaxBackoff, func() error {
+			if err := pgStorage.InitSchema(); err != nil {
+				return err
+			}
+			return clientManager.Resync(context.Background())
+		}, stopMonitor)
+	}
+
+	var bans *ratelimiter.BanTracker
+	if cfg.Bans.Enabled {
+		var banStorage ratelimiter.BanStorage
+		if cfg.Bans.Shared && pgStorage != nil {
+			banStorage = pgStorage
+		} else if cfg.Bans.Shared {
+			log.Printf("Shared bans require the postgres storage driver, keeping bans local")
+		}
+		bans = ratelimiter.NewBanTracker(&ratelimiter.BanConfig{
+			Threshold:    cfg.Bans.Threshold,
+			Window:       cfg.Bans.Window,
+			Duration:     cfg.Bans.Duration,
+			MaxDuration:  cfg.Bans.MaxDuration,
+			Multiplier:   cfg.Bans.Multiplier,
+			ForgetAfter:  cfg.Bans.ForgetAfter,
+			SyncInterval: cfg.Bans.SyncInterval,
+		}, banStorage)
+		defer bans.Stop()
+	}
+
+	srv := server.NewServer(lb, rl, clientManager, quotas, adaptiveLimiter, shedder, acl, bans)
+	srv.SetTrustedProxies(trustedProxies)
+	srv.SetReadyFailWhenDegraded(cfg.Server.ReadyFailWhenDegraded)
 
 	httpServer := &http.Server{
 		Addr:    ":" + cfg.Server.Port,
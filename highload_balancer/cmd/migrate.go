@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"github.com/se1y4/highload-balancer/internal/config"
+	"github.com/se1y4/highload-balancer/internal/ratelimiter"
+)
+
+const migrateUsage = "usage: load-balancer migrate up | down [steps] | status"
+
+// runMigrate handles "migrate up", "migrate down [steps]", which reverts
+// one migration by default, and "migrate status".
+func runMigrate(cfg *config.Config, args []string) {
+	if len(args) == 0 {
+		log.Fatal(migrateUsage)
+	}
+
+	pgStorage, err := ratelimiter.NewPostgresStorage(&ratelimiter.PostgresConfig{ConnString: cfg.Postgres.ConnString})
+	if err != nil {
+		log.Fatalf("Failed to init PostgreSQL: %v", err)
+	}
+	defer pgStorage.Close()
+
+	m, err := pgStorage.Migrator()
+	if err != nil {
+		log.Fatalf("Failed to load migrations: %v", err)
+	}
+	ctx := context.Background()
+
+	switch args[0] {
+	case "up":
+		applied, err := m.Up(ctx)
+		for _, mig := range applied {
+			fmt.Printf("applied %04d_%s\n", mig.Version, mig.Name)
+		}
+		if err != nil {
+			log.Fatalf("Migration failed: %v", err)
+		}
+		if len(applied) == 0 {
+			fmt.Println("schema is up to date")
+		}
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			if steps, err = strconv.Atoi(args[1]); err != nil || steps < 1 {
+				log.Fatal(migrateUsage)
+			}
+		}
+		reverted, err := m.Down(ctx, steps)
+		for _, mig := range reverted {
+			fmt.Printf("reverted %04d_%s\n", mig.Version, mig.Name)
+		}
+		if err != nil {
+			log.Fatalf("Migration failed: %v", err)
+		}
+	case "status":
+		statuses, err := m.Status(ctx)
+		if err != nil {
+			log.Fatalf("Failed to read migration status: %v", err)
+		}
+		none := true
+		for _, s := range statuses {
+			if s.AppliedAt != nil {
+				none = false
+			}
+		}
+		if none {
+			fmt.Println("no migrations applied")
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "VERSION\tNAME\tAPPLIED")
+		for _, s := range statuses {
+			applied := "pending"
+			if s.AppliedAt != nil {
+				applied = s.AppliedAt.Format(time.RFC3339)
+			}
+			fmt.Fprintf(w, "%04d\t%s\t%s\n", s.Version, s.Name, applied)
+		}
+		w.Flush()
+	default:
+		log.Fatal(migrateUsage)
+	}
+}
@@ -3,6 +3,16 @@ module github.com/se1y4/highload-balancer
 go 1.23.4
 
 require (
+	github.com/alicebob/miniredis/v2 v2.37.0
 	github.com/lib/pq v1.10.9
+	github.com/redis/go-redis/v9 v9.9.0
+	go.etcd.io/bbolt v1.4.3
 	gopkg.in/yaml.v2 v2.4.0
 )
+
+require (
+	github.com/cespare/xxhash/v2 v2.3.0 // indirect
+	github.com/dgryski/go-rendezvous v0.0.0-20200823014737-9f7001d12a5f // indirect
+	github.com/yuin/gopher-lua v1.1.1 // indirect
+	golang.org/x/sys v0.29.0 // indirect
+)
@@ -0,0 +1,80 @@
+package adaptive
+
+import (
+	"math"
+	"time"
+)
+
+// Algorithm derives a new in-flight limit from the current one and a
+// finished request.
+type Algorithm interface {
+	Update(limit float64, rtt time.Duration, inFlight int, outcome Outcome) float64
+}
+
+func newAlgorithm(config *Config) Algorithm {
+	switch config.Algorithm {
+	case GradientAlgorithm:
+		return &Gradient{
+			smoothing: config.Smoothing,
+			window:    float64(config.Window),
+		}
+	default:
+		return &AIMD{
+			threshold: config.Threshold,
+			backoff:   config.Backoff,
+		}
+	}
+}
+
+// AIMD adds one slot per successful request while the limit is in use
+// and cuts it multiplicatively on errors or slow responses.
+type AIMD struct {
+	threshold time.Duration
+	backoff   float64
+}
+
+func (a *AIMD) Update(limit float64, rtt time.Duration, inFlight int, outcome Outcome) float64 {
+	if outcome == Dropped || (a.threshold > 0 && rtt > a.threshold) {
+		return limit * a.backoff
+	}
+	// Growing a limit nobody reaches would only delay the next backoff.
+	if float64(inFlight)*2 >= limit {
+		return limit + 1/limit
+	}
+	return limit
+}
+
+// Gradient compares recent latency against a long-term average: as
+// backends queue work, recent latency grows and the limit shrinks in
+// proportion, with a sqrt(limit) allowance for normal queueing.
+type Gradient struct {
+	smoothing float64
+	window    float64
+	longRTT   float64
+}
+
+func (g *Gradient) Update(limit float64, rtt time.Duration, inFlight int, outcome Outcome) float64 {
+	sample := rtt.Seconds()
+	if outcome == Dropped {
+		// Treat a failure as a very slow response.
+		sample = math.Max(sample, 2*g.longRTT)
+	}
+	if g.longRTT == 0 {
+		g.longRTT = sample
+		return limit
+	}
+	g.longRTT += (sample - g.longRTT) / g.window
+
+	// Let the baseline recover quickly once a slowdown is over.
+	if g.longRTT/sample > 2 {
+		g.longRTT *= 0.95
+	}
+
+	if float64(inFlight)*2 < limit {
+		return limit
+	}
+
+	gradient := math.Max(0.5, math.Min(1, g.longRTT/sample))
+	estimate := limit*gradient + math.Sqrt(limit)
+	return limit*(1-g.smoothing) + estimate*g.smoothing
+}
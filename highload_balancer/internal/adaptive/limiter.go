@@ -0,0 +1,98 @@
+package adaptive
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	"github.com/se1y4/highload-balancer/internal/metrics"
+)
+
+// Limiter caps the number of requests in flight to the backends and
+// moves the cap with observed backend latency, so that load is shed at
+// the balancer instead of piling up on a slow backend.
+type Limiter struct {
+	algorithm Algorithm
+	limit     float64
+	minLimit  float64
+	maxLimit  float64
+	inFlight  int
+	mux       sync.Mutex
+}
+
+func NewLimiter(config *Config) *Limiter {
+	if config.MinLimit <= 0 {
+		config.MinLimit = 1
+	}
+	if config.MaxLimit < config.MinLimit {
+		config.MaxLimit = 1000
+	}
+	if config.InitialLimit <= 0 {
+		config.InitialLimit = config.MinLimit
+	}
+	if config.Backoff <= 0 || config.Backoff >= 1 {
+		config.Backoff = 0.9
+	}
+	if config.Smoothing <= 0 || config.Smoothing > 1 {
+		config.Smoothing = 0.2
+	}
+	if config.Window <= 0 {
+		config.Window = 600
+	}
+
+	l := &Limiter{
+		algorithm: newAlgorithm(config),
+		minLimit:  float64(config.MinLimit),
+		maxLimit:  float64(config.MaxLimit),
+	}
+	l.limit = l.clamp(float64(config.InitialLimit))
+	metrics.AdaptiveLimit.Set(int64(l.limit))
+	return l
+}
+
+// Acquire takes an in-flight slot if the current limit allows it. The
+// caller must call release exactly once with the request's latency.
+func (l *Limiter) Acquire() (func(rtt time.Duration, outcome Outcome), bool) {
+	l.mux.Lock()
+	defer l.mux.Unlock()
+
+	if l.inFlight >= int(l.limit) {
+		return nil, false
+	}
+	l.inFlight++
+	metrics.AdaptiveInFlight.Add(1)
+
+	return l.release, true
+}
+
+func (l *Limiter) release(rtt time.Duration, outcome Outcome) {
+	l.mux.Lock()
+	defer l.mux.Unlock()
+
+	if outcome != Ignored {
+		// Sample with the in-flight count the request saw.
+		l.limit = l.clamp(l.algorithm.Update(l.limit, rtt, l.inFlight, outcome))
+		metrics.AdaptiveLimit.Set(int64(l.limit))
+	}
+	l.inFlight--
+	metrics.AdaptiveInFlight.Add(-1)
+}
+
+func (l *Limiter) clamp(limit float64) float64 {
+	if math.IsNaN(limit) {
+		return l.minLimit
+	}
+	return math.Max(l.minLimit, math.Min(l.maxLimit, limit))
+}
+
+func (l *Limiter) Limit() int {
+	l.mux.Lock()
+	defer l.mux.Unlock()
+	return int(l.limit)
+}
+
+func (l *Limiter) InFlight() int {
+	l.mux.Lock()
+	defer l.mux.Unlock()
+	return l.inFlight
+}
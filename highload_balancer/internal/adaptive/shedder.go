@@ -0,0 +1,116 @@
+package adaptive
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/se1y4/highload-balancer/internal/metrics"
+)
+
+// Shedder rejects low-priority requests first while the backends are
+// saturated. Requests with a priority below the current level are shed;
+// MaxPriority and above are never shed.
+type Shedder struct {
+	config   *ShedderConfig
+	capacity func() int
+	inFlight atomic.Int64
+	level    atomic.Int32
+	stopChan chan struct{}
+}
+
+// NewShedder measures utilization against capacity, or against a fixed
+// MaxInFlight when capacity is nil. Pass an adaptive Limiter's Limit to
+// start shedding before the limiter rejects everyone alike.
+func NewShedder(config *ShedderConfig, capacity func() int) *Shedder {
+	if config.MaxInFlight <= 0 {
+		config.MaxInFlight = 1000
+	}
+	if config.MaxPriority <= 0 {
+		config.MaxPriority = 3
+	}
+	if config.HighWatermark <= 0 {
+		config.HighWatermark = 0.9
+	}
+	if config.LowWatermark <= 0 || config.LowWatermark >= config.HighWatermark {
+		config.LowWatermark = config.HighWatermark * 0.7
+	}
+	if config.Interval <= 0 {
+		config.Interval = 100 * time.Millisecond
+	}
+	if config.Cooldown < config.Interval {
+		config.Cooldown = 10 * config.Interval
+	}
+	if capacity == nil {
+		capacity = func() int { return config.MaxInFlight }
+	}
+
+	return &Shedder{
+		config:   config,
+		capacity: capacity,
+		stopChan: make(chan struct{}),
+	}
+}
+
+func (s *Shedder) Start() {
+	go s.run()
+}
+
+func (s *Shedder) Stop() {
+	close(s.stopChan)
+}
+
+// Admit reports whether a request of the given priority may proceed.
+func (s *Shedder) Admit(priority int) bool {
+	return priority >= int(s.level.Load())
+}
+
+// Track counts a request as in flight until the returned func is called.
+func (s *Shedder) Track() func() {
+	s.inFlight.Add(1)
+	return func() { s.inFlight.Add(-1) }
+}
+
+func (s *Shedder) Level() int {
+	return int(s.level.Load())
+}
+
+func (s *Shedder) run() {
+	ticker := time.NewTicker(s.config.Interval)
+	defer ticker.Stop()
+
+	var calmSince time.Time
+	for {
+		select {
+		case <-ticker.C:
+		case <-s.stopChan:
+			return
+		}
+
+		now := time.Now()
+		level := s.level.Load()
+		utilization := float64(s.inFlight.Load()) / float64(max(s.capacity(), 1))
+
+		// Between the watermarks the level holds, which keeps it from
+		// flapping around a single threshold.
+		switch {
+		case utilization >= s.config.HighWatermark:
+			calmSince = time.Time{}
+			if level < int32(s.config.MaxPriority) {
+				level++
+			}
+		case utilization <= s.config.LowWatermark && level > 0:
+			if calmSince.IsZero() {
+				calmSince = now
+			} else if now.Sub(calmSince) >= s.config.Cooldown {
+				level--
+				calmSince = now
+			}
+		default:
+			calmSince = time.Time{}
+		}
+
+		if level != s.level.Swap(level) {
+			metrics.ShedLevel.Set(int64(level))
+		}
+	}
+}
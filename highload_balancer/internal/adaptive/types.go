@@ -0,0 +1,53 @@
+package adaptive
+
+import "time"
+
+type AlgorithmType string
+
+const (
+	AIMDAlgorithm     AlgorithmType = "aimd"
+	GradientAlgorithm AlgorithmType = "gradient"
+)
+
+// Outcome tells the limiter how a proxied request went.
+type Outcome int
+
+const (
+	// Success is a completed request whose latency is a useful sample.
+	Success Outcome = iota
+	// Dropped is a request the backend failed or timed out on.
+	Dropped
+	// Ignored requests release their slot without a sample, e.g. when
+	// the client went away first.
+	Ignored
+)
+
+type Config struct {
+	Algorithm    AlgorithmType
+	InitialLimit int
+	MinLimit     int
+	MaxLimit     int
+
+	// AIMD: latency above Threshold counts as a drop; the limit is
+	// multiplied by Backoff on every drop.
+	Threshold time.Duration
+	Backoff   float64
+
+	// Gradient: weight of a new limit estimate and of a new sample in
+	// the long-term latency average.
+	Smoothing float64
+	Window    int
+}
+
+// ShedderConfig drives priority load shedding. Utilization is in-flight
+// requests over capacity; above HighWatermark one more priority level is
+// shed every Interval, and a level is restored only after utilization
+// stays below LowWatermark for Cooldown.
+type ShedderConfig struct {
+	MaxInFlight   int
+	MaxPriority   int
+	HighWatermark float64
+	LowWatermark  float64
+	Interval      time.Duration
+	Cooldown      time.Duration
+}
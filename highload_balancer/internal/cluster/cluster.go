@@ -0,0 +1,243 @@
+package cluster
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"log"
+	"net"
+	"net/netip"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/se1y4/highload-balancer/internal/metrics"
+)
+
+const (
+	heartbeatPrefix = "hb "
+	maxReadBackoff  = time.Second
+)
+
+type peerState struct {
+	id       string
+	lastSeen time.Time
+}
+
+// Membership tracks which balancer instances are alive by exchanging UDP
+// heartbeats with a static peer list. Heartbeats are accepted only from
+// the addresses of configured peers, each of which counts for at most one
+// node. Peers are identified by the node ID they announce, so one
+// instance reachable under several addresses is counted once.
+type Membership struct {
+	id     string
+	conn   *net.UDPConn
+	peers  []string
+	config *Config
+	// allowed holds the resolved peer addresses, refreshed with every
+	// heartbeat so that peers may change address.
+	allowed  map[netip.AddrPort]bool
+	live     map[netip.AddrPort]peerState
+	size     int
+	onChange []func(int)
+	mux      sync.Mutex
+	// resizeMux keeps size changes and their callbacks in order.
+	resizeMux sync.Mutex
+	stopChan  chan struct{}
+	wg        sync.WaitGroup
+}
+
+func NewMembership(config *Config) (*Membership, error) {
+	addr, err := net.ResolveUDPAddr("udp", config.Bind)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.ListenUDP("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	id := config.NodeID
+	if id == "" {
+		id = randomID()
+	}
+	if config.HeartbeatInterval <= 0 {
+		config.HeartbeatInterval = 500 * time.Millisecond
+	}
+	if config.PeerTimeout <= 0 {
+		config.PeerTimeout = 4 * config.HeartbeatInterval
+	}
+
+	return &Membership{
+		id:       id,
+		conn:     conn,
+		peers:    config.Peers,
+		config:   config,
+		allowed:  make(map[netip.AddrPort]bool),
+		live:     make(map[netip.AddrPort]peerState),
+		size:     1,
+		stopChan: make(chan struct{}),
+	}, nil
+}
+
+// OnChange registers a callback invoked with the new cluster size,
+// including this instance, whenever a peer joins or leaves.
+func (m *Membership) OnChange(fn func(int)) {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	m.onChange = append(m.onChange, fn)
+}
+
+func (m *Membership) Size() int {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	return m.size
+}
+
+func (m *Membership) Start() {
+	log.Printf("Cluster node %s listening on %s with %d peers", m.id, m.conn.LocalAddr(), len(m.peers))
+	m.wg.Add(2)
+	go m.receive()
+	go m.heartbeat()
+}
+
+func (m *Membership) Stop() {
+	close(m.stopChan)
+	m.conn.Close()
+	m.wg.Wait()
+}
+
+func (m *Membership) heartbeat() {
+	defer m.wg.Done()
+
+	ticker := time.NewTicker(m.config.HeartbeatInterval)
+	defer ticker.Stop()
+
+	msg := []byte(heartbeatPrefix + m.id)
+	for {
+		allowed := make(map[netip.AddrPort]bool, len(m.peers))
+		for _, peer := range m.peers {
+			addr, err := net.ResolveUDPAddr("udp", peer)
+			if err != nil {
+				continue
+			}
+			allowed[addrKey(addr)] = true
+			m.conn.WriteToUDP(msg, addr)
+		}
+		m.mux.Lock()
+		m.allowed = allowed
+		m.mux.Unlock()
+		m.expire(time.Now())
+
+		select {
+		case <-ticker.C:
+		case <-m.stopChan:
+			return
+		}
+	}
+}
+
+func (m *Membership) receive() {
+	defer m.wg.Done()
+
+	buf := make([]byte, 256)
+	backoff := time.Duration(0)
+	for {
+		n, from, err := m.conn.ReadFromUDP(buf)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			backoff = min(max(2*backoff, 10*time.Millisecond), maxReadBackoff)
+			log.Printf("Cluster receive failed, retrying in %v: %v", backoff, err)
+			select {
+			case <-time.After(backoff):
+				continue
+			case <-m.stopChan:
+				return
+			}
+		}
+		backoff = 0
+
+		msg := string(buf[:n])
+		if !strings.HasPrefix(msg, heartbeatPrefix) {
+			continue
+		}
+		if id := strings.TrimPrefix(msg, heartbeatPrefix); id != m.id {
+			m.seen(addrKey(from), id, time.Now())
+		}
+	}
+}
+
+func addrKey(addr *net.UDPAddr) netip.AddrPort {
+	ap := addr.AddrPort()
+	return netip.AddrPortFrom(ap.Addr().Unmap(), ap.Port())
+}
+
+// seen records a heartbeat. Heartbeats from unknown addresses are
+// dropped, so nobody outside the peer list can inflate the cluster size.
+func (m *Membership) seen(from netip.AddrPort, id string, now time.Time) {
+	m.mux.Lock()
+	if !m.allowed[from] {
+		m.mux.Unlock()
+		return
+	}
+	prev, known := m.live[from]
+	m.live[from] = peerState{id: id, lastSeen: now}
+	m.mux.Unlock()
+
+	if !known || prev.id != id {
+		log.Printf("Cluster peer %s joined from %s", id, from)
+		m.resize()
+	}
+}
+
+func (m *Membership) expire(now time.Time) {
+	m.mux.Lock()
+	changed := false
+	for addr, peer := range m.live {
+		if now.Sub(peer.lastSeen) > m.config.PeerTimeout || !m.allowed[addr] {
+			delete(m.live, addr)
+			log.Printf("Cluster peer %s left", peer.id)
+			changed = true
+		}
+	}
+	m.mux.Unlock()
+
+	if changed {
+		m.resize()
+	}
+}
+
+// resize recounts live nodes and notifies callbacks of a change. It is
+// called from both the receive and the heartbeat goroutine; resizeMux
+// makes callbacks see sizes in the order they were computed.
+func (m *Membership) resize() {
+	m.resizeMux.Lock()
+	defer m.resizeMux.Unlock()
+
+	m.mux.Lock()
+	ids := make(map[string]bool, len(m.live))
+	for _, peer := range m.live {
+		ids[peer.id] = true
+	}
+	size := len(ids) + 1
+	if size == m.size {
+		m.mux.Unlock()
+		return
+	}
+	m.size = size
+	callbacks := append([]func(int){}, m.onChange...)
+	m.mux.Unlock()
+
+	metrics.ClusterSize.Set(int64(size))
+	for _, fn := range callbacks {
+		fn(size)
+	}
+}
+
+func randomID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
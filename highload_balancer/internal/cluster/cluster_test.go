@@ -0,0 +1,131 @@
+package cluster
+
+import (
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+// startCluster runs n memberships on localhost, each listing all of them
+// as peers, itself included, as a shared config file would.
+func startCluster(t *testing.T, n int) []*Membership {
+	t.Helper()
+	var (
+		members []*Membership
+		peers   []string
+	)
+	for i := 0; i < n; i++ {
+		m, err := NewMembership(&Config{
+			Bind:              "127.0.0.1:0",
+			HeartbeatInterval: 20 * time.Millisecond,
+			PeerTimeout:       100 * time.Millisecond,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		members = append(members, m)
+		peers = append(peers, m.conn.LocalAddr().String())
+	}
+	for _, m := range members {
+		m.peers = peers
+		m.Start()
+	}
+	return members
+}
+
+func waitForSize(t *testing.T, m *Membership, want int) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for m.Size() != want {
+		if time.Now().After(deadline) {
+			t.Fatalf("cluster size %d, want %d", m.Size(), want)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestMembershipJoinAndLeave(t *testing.T) {
+	members := startCluster(t, 3)
+	defer members[0].Stop()
+	defer members[1].Stop()
+
+	var (
+		sizes []int
+		mux   sync.Mutex
+	)
+	members[0].OnChange(func(n int) {
+		mux.Lock()
+		sizes = append(sizes, n)
+		mux.Unlock()
+	})
+
+	for _, m := range members {
+		waitForSize(t, m, 3)
+	}
+
+	members[2].Stop()
+	waitForSize(t, members[0], 2)
+	waitForSize(t, members[1], 2)
+
+	mux.Lock()
+	defer mux.Unlock()
+	if last := sizes[len(sizes)-1]; last != 2 {
+		t.Fatalf("last size reported to OnChange = %d, want 2 (all: %v)", last, sizes)
+	}
+}
+
+func TestMembershipIgnoresUnknownSenders(t *testing.T) {
+	members := startCluster(t, 2)
+	defer members[0].Stop()
+	defer members[1].Stop()
+	waitForSize(t, members[0], 2)
+
+	spoofer, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer spoofer.Close()
+
+	target := members[0].conn.LocalAddr().(*net.UDPAddr)
+	for i := 0; i < 20; i++ {
+		id := string(rune('a' + i))
+		spoofer.WriteToUDP([]byte(heartbeatPrefix+"spoofed-"+id), target)
+	}
+	time.Sleep(100 * time.Millisecond)
+
+	if size := members[0].Size(); size != 2 {
+		t.Fatalf("cluster size %d after heartbeats from an unknown address, want 2", size)
+	}
+}
+
+func TestMembershipOneNodePerAddress(t *testing.T) {
+	members := startCluster(t, 2)
+	defer members[0].Stop()
+	defer members[1].Stop()
+	waitForSize(t, members[0], 2)
+
+	// A peer announcing a new ID replaces its old one instead of adding
+	// a node.
+	from := addrKey(members[1].conn.LocalAddr().(*net.UDPAddr))
+	for _, id := range []string{"x", "y", "z"} {
+		members[0].seen(from, id, time.Now())
+	}
+	if size := members[0].Size(); size != 2 {
+		t.Fatalf("cluster size %d, want 2", size)
+	}
+}
+
+func TestMembershipStopsOnClose(t *testing.T) {
+	members := startCluster(t, 1)
+	done := make(chan struct{})
+	go func() {
+		members[0].Stop()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Stop did not return")
+	}
+}
@@ -0,0 +1,11 @@
+package cluster
+
+import "time"
+
+type Config struct {
+	NodeID            string
+	Bind              string
+	Peers             []string
+	HeartbeatInterval time.Duration
+	PeerTimeout       time.Duration
+}
@@ -9,21 +9,109 @@ import (
 
 type Config struct {
 	Server struct {
-		Port string `yaml:"port"`
+		Port                  string   `yaml:"port"`
+		TrustedProxies        []string `yaml:"trusted_proxies"`
+		ReadyFailWhenDegraded bool     `yaml:"ready_fail_when_degraded"`
 	} `yaml:"server"`
 	Backends    []string `yaml:"backends"`
 	RateLimiter struct {
 		DefaultCapacity int           `yaml:"default_capacity"`
 		DefaultRate     int           `yaml:"default_rate"`
-		RefillInterval  time.Duration `yaml:"refill_interval"`
+		Algorithm       string        `yaml:"algorithm"`
+		MaxBuckets      int           `yaml:"max_buckets"`
+		IdleTTL         time.Duration `yaml:"idle_ttl"`
+		Shards          int           `yaml:"shards"`
+		Backend         string        `yaml:"backend"`
+		SyncPeriod      time.Duration `yaml:"sync_period"`
+		MaxOvershoot    float64       `yaml:"max_overshoot"`
+		Routes          []RouteRule   `yaml:"routes"`
+		CostHeader      string        `yaml:"cost_header"`
+		BodyCostBytes   int64         `yaml:"body_cost_bytes"`
 	} `yaml:"rate_limiter"`
+	Overrides struct {
+		Timezone      string        `yaml:"timezone"`
+		CheckInterval time.Duration `yaml:"check_interval"`
+	} `yaml:"overrides"`
+	Quota struct {
+		Timezone      string        `yaml:"timezone"`
+		FlushInterval time.Duration `yaml:"flush_interval"`
+	} `yaml:"quota"`
 	Balancer struct {
 		Strategy            string        `yaml:"strategy"`
 		HealthCheckInterval time.Duration `yaml:"health_check_interval"`
 	} `yaml:"balancer"`
+	Adaptive struct {
+		Enabled      bool          `yaml:"enabled"`
+		Algorithm    string        `yaml:"algorithm"`
+		InitialLimit int           `yaml:"initial_limit"`
+		MinLimit     int           `yaml:"min_limit"`
+		MaxLimit     int           `yaml:"max_limit"`
+		Threshold    time.Duration `yaml:"latency_threshold"`
+		Backoff      float64       `yaml:"backoff"`
+		Smoothing    float64       `yaml:"smoothing"`
+		Window       int           `yaml:"window"`
+	} `yaml:"adaptive"`
+	Shedding struct {
+		Enabled       bool          `yaml:"enabled"`
+		MaxInFlight   int           `yaml:"max_in_flight"`
+		MaxPriority   int           `yaml:"max_priority"`
+		HighWatermark float64       `yaml:"high_watermark"`
+		LowWatermark  float64       `yaml:"low_watermark"`
+		Interval      time.Duration `yaml:"interval"`
+		Cooldown      time.Duration `yaml:"cooldown"`
+	} `yaml:"shedding"`
+	Bans struct {
+		Enabled      bool          `yaml:"enabled"`
+		Threshold    int           `yaml:"threshold"`
+		Window       time.Duration `yaml:"window"`
+		Duration     time.Duration `yaml:"duration"`
+		MaxDuration  time.Duration `yaml:"max_duration"`
+		Multiplier   float64       `yaml:"multiplier"`
+		ForgetAfter  time.Duration `yaml:"forget_after"`
+		Shared       bool          `yaml:"shared"`
+		SyncInterval time.Duration `yaml:"sync_interval"`
+	} `yaml:"bans"`
+	Cluster struct {
+		NodeID            string        `yaml:"node_id"`
+		Bind              string        `yaml:"bind"`
+		Peers             []string      `yaml:"peers"`
+		HeartbeatInterval time.Duration `yaml:"heartbeat_interval"`
+		PeerTimeout       time.Duration `yaml:"peer_timeout"`
+	} `yaml:"cluster"`
+	Redis struct {
+		Addr          string        `yaml:"addr"`
+		Password      string        `yaml:"password"`
+		DB            int           `yaml:"db"`
+		KeyPrefix     string        `yaml:"key_prefix"`
+		Timeout       time.Duration `yaml:"timeout"`
+		FallbackRetry time.Duration `yaml:"fallback_retry"`
+	} `yaml:"redis"`
+	Storage struct {
+		Driver string `yaml:"driver"`
+		Path   string `yaml:"path"`
+	} `yaml:"storage"`
 	Postgres struct {
-        ConnString string `yaml:"conn_string"`
-    } `yaml:"postgres"`
+		ConnString      string        `yaml:"conn_string"`
+		QueryTimeout    time.Duration `yaml:"query_timeout"`
+		MaxOpenConns    int           `yaml:"max_open_conns"`
+		MaxIdleConns    int           `yaml:"max_idle_conns"`
+		ConnMaxLifetime time.Duration `yaml:"conn_max_lifetime"`
+		ResyncInterval  time.Duration `yaml:"resync_interval"`
+		SnapshotPath    string        `yaml:"snapshot_path"`
+		HealthInterval  time.Duration `yaml:"health_interval"`
+		MaxBackoff      time.Duration `yaml:"max_backoff"`
+	} `yaml:"postgres"`
+}
+
+type RouteRule struct {
+	Name       string `yaml:"name"`
+	Method     string `yaml:"method"`
+	Host       string `yaml:"host"`
+	PathPrefix string `yaml:"path_prefix"`
+	Capacity   int    `yaml:"capacity"`
+	RatePerSec int    `yaml:"rate_per_sec"`
+	Algorithm  string `yaml:"algorithm"`
+	Cost       int    `yaml:"cost"`
 }
 
 func LoadConfig(path string) (*Config, error) {
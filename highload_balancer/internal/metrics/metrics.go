@@ -0,0 +1,29 @@
+package metrics
+
+import (
+	"expvar"
+	"net/http"
+)
+
+var (
+	Buckets        = expvar.NewInt("ratelimiter_buckets")
+	BucketEviction = expvar.NewMap("ratelimiter_bucket_evictions")
+
+	SharedStoreFallbacks = expvar.NewInt("ratelimiter_shared_store_fallbacks")
+
+	QueueWaiting  = expvar.NewInt("ratelimiter_queue_waiting")
+	QueueOutcomes = expvar.NewMap("ratelimiter_queue_outcomes")
+
+	ClusterSize = expvar.NewInt("cluster_size")
+
+	AdaptiveLimit    = expvar.NewInt("adaptive_limit")
+	AdaptiveInFlight = expvar.NewInt("adaptive_in_flight")
+	ShedLevel        = expvar.NewInt("shed_level")
+
+	Rejections = expvar.NewMap("server_rejections")
+	Bans       = expvar.NewInt("ratelimiter_bans")
+)
+
+func Handler() http.Handler {
+	return expvar.Handler()
+}
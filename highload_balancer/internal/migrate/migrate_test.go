@@ -0,0 +1,100 @@
+package migrate
+
+import (
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/se1y4/highload-balancer/migrations"
+)
+
+func file(body string) *fstest.MapFile {
+	return &fstest.MapFile{Data: []byte(body)}
+}
+
+func TestLoad(t *testing.T) {
+	got, err := Load(fstest.MapFS{
+		"0010_later.up.sql":    file("CREATE TABLE later ()"),
+		"0002_second.up.sql":   file("CREATE TABLE second ()"),
+		"0002_second.down.sql": file("DROP TABLE second"),
+		"0001_first.up.sql":    file("CREATE TABLE first ()"),
+		"README.md":            file("not a migration"),
+		"0003_skip.sql":        file("neither up nor down"),
+		"0004_dir.up.sql/x":    file("inside a directory"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []Migration{
+		{Version: 1, Name: "first", Up: "CREATE TABLE first ()"},
+		{Version: 2, Name: "second", Up: "CREATE TABLE second ()", Down: "DROP TABLE second"},
+		{Version: 10, Name: "later", Up: "CREATE TABLE later ()"},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("Load returned %d migrations, want %d", len(got), len(want))
+	}
+	for i := range want {
+		if *got[i] != want[i] {
+			t.Errorf("migration %d = %+v, want %+v", i, *got[i], want[i])
+		}
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	for name, c := range map[string]struct {
+		fsys fstest.MapFS
+		want string
+	}{
+		"two names": {fstest.MapFS{
+			"0001_init.up.sql":    file("CREATE TABLE a ()"),
+			"0001_other.down.sql": file("DROP TABLE a"),
+		}, "two names"},
+		"no up file": {fstest.MapFS{
+			"0001_init.up.sql":   file("CREATE TABLE a ()"),
+			"0002_next.down.sql": file("DROP TABLE b"),
+		}, "2_next has no up file"},
+	} {
+		_, err := Load(c.fsys)
+		if err == nil || !strings.Contains(err.Error(), c.want) {
+			t.Errorf("%s: Load error = %v, want one mentioning %q", name, err, c.want)
+		}
+	}
+}
+
+func TestEmbeddedMigrations(t *testing.T) {
+	loaded, err := Load(migrations.FS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, m := range loaded {
+		if m.Version != int64(i+1) {
+			t.Errorf("migration %d_%s out of sequence at position %d", m.Version, m.Name, i+1)
+		}
+		if m.Down == "" {
+			t.Errorf("migration %d_%s has no down file", m.Version, m.Name)
+		}
+	}
+}
+
+func TestStatuses(t *testing.T) {
+	known := []*Migration{{Version: 1, Name: "first"}, {Version: 2, Name: "second"}}
+	at := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	got := statuses(known, map[int64]time.Time{})
+	if len(got) != 2 || got[0].AppliedAt != nil || got[1].AppliedAt != nil {
+		t.Fatalf("statuses without applied versions = %+v, want both pending", got)
+	}
+
+	got = statuses(known, map[int64]time.Time{1: at, 7: at})
+	if len(got) != 3 {
+		t.Fatalf("statuses = %+v, want 3", got)
+	}
+	if got[0].AppliedAt == nil || got[1].AppliedAt != nil {
+		t.Errorf("statuses = %+v, want the first applied and the second pending", got[:2])
+	}
+	if got[2].Version != 7 || got[2].Name != "unknown" {
+		t.Errorf("applied version without a file = %+v, want 7 unknown", got[2])
+	}
+}
@@ -0,0 +1,224 @@
+package ratelimiter
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/netip"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	ErrACLEntryNotFound = errors.New("acl entry not found")
+	ErrInvalidACLEntry  = errors.New("invalid acl entry")
+)
+
+type ACLAction string
+
+const (
+	// ACLAllow exempts matching addresses from rate limiting.
+	ACLAllow ACLAction = "allow"
+	// ACLDeny rejects matching addresses outright.
+	ACLDeny ACLAction = "deny"
+	// ACLNone means no entry matched.
+	ACLNone ACLAction = ""
+)
+
+// ACLEntry applies Action to every address in CIDR until ExpiresAt, or
+// forever when ExpiresAt is nil. When entries overlap, the most specific
+// prefix wins, so an allowed host can sit inside a denied range.
+type ACLEntry struct {
+	CIDR      string     `json:"cidr"`
+	Action    ACLAction  `json:"action"`
+	Comment   string     `json:"comment,omitempty"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+func (e *ACLEntry) expired(now time.Time) bool {
+	return e.ExpiresAt != nil && !now.Before(*e.ExpiresAt)
+}
+
+type ACLStorage interface {
+	SaveACLEntry(context.Context, *ACLEntry) error
+	DeleteACLEntry(ctx context.Context, cidr string) error
+	GetACLEntries(context.Context) ([]*ACLEntry, error)
+}
+
+// ACL keeps allow/deny entries in memory for lookups on every request.
+// Writes rebuild the prefix trie and swap it in, so lookups never lock.
+type ACL struct {
+	storage ACLStorage
+	entries map[string]*ACLEntry
+	trie    atomic.Pointer[prefixTrie[*ACLEntry]]
+	mux     sync.Mutex
+}
+
+func NewACL(storage ACLStorage) *ACL {
+	acl := &ACL{
+		storage: storage,
+		entries: make(map[string]*ACLEntry),
+	}
+	acl.trie.Store(newPrefixTrie[*ACLEntry]())
+	if err := acl.Reload(context.Background()); err != nil {
+		log.Printf("Failed to load ACL entries: %v", err)
+	}
+	return acl
+}
+
+// Reload replaces the entries in memory with those in storage. On error
+// the current entries are kept.
+func (a *ACL) Reload(ctx context.Context) error {
+	entries, err := a.storage.GetACLEntries(ctx)
+	if err != nil {
+		return err
+	}
+
+	a.mux.Lock()
+	defer a.mux.Unlock()
+	a.entries = make(map[string]*ACLEntry, len(entries))
+	for _, entry := range entries {
+		a.entries[entry.CIDR] = entry
+	}
+	a.rebuildLocked()
+	return nil
+}
+
+// Lookup returns the action of the most specific unexpired entry
+// covering ip, or ACLNone.
+func (a *ACL) Lookup(ip string) ACLAction {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return ACLNone
+	}
+
+	now := time.Now()
+	action := ACLNone
+	a.trie.Load().lookup(addr, func(entry *ACLEntry) bool {
+		if entry.expired(now) {
+			return false
+		}
+		action = entry.Action
+		return true
+	})
+	return action
+}
+
+// Add stores entry, replacing any entry for the same prefix. The CIDR is
+// normalized, so "10.0.0.1/8" and "10.0.0.0/8" are the same entry.
+func (a *ACL) Add(ctx context.Context, entry *ACLEntry) error {
+	if entry.Action != ACLAllow && entry.Action != ACLDeny {
+		return ErrInvalidACLEntry
+	}
+	prefix, err := parsePrefix(entry.CIDR)
+	if err != nil {
+		return ErrInvalidACLEntry
+	}
+	entry.CIDR = prefix.String()
+	entry.CreatedAt = time.Now()
+
+	if err := a.storage.SaveACLEntry(ctx, entry); err != nil {
+		return err
+	}
+
+	a.mux.Lock()
+	defer a.mux.Unlock()
+	a.entries[entry.CIDR] = entry
+	a.rebuildLocked()
+	return nil
+}
+
+func (a *ACL) Remove(ctx context.Context, cidr string) error {
+	prefix, err := parsePrefix(cidr)
+	if err != nil {
+		return ErrInvalidACLEntry
+	}
+	cidr = prefix.String()
+
+	a.mux.Lock()
+	defer a.mux.Unlock()
+	if _, exists := a.entries[cidr]; !exists {
+		return ErrACLEntryNotFound
+	}
+	if err := a.storage.DeleteACLEntry(ctx, cidr); err != nil {
+		return err
+	}
+	delete(a.entries, cidr)
+	a.rebuildLocked()
+	return nil
+}
+
+// List returns unexpired entries sorted by CIDR. Expired entries are
+// left for Prune.
+func (a *ACL) List(action ACLAction) []*ACLEntry {
+	now := time.Now()
+
+	a.mux.Lock()
+	entries := make([]*ACLEntry, 0, len(a.entries))
+	for _, entry := range a.entries {
+		if entry.expired(now) {
+			continue
+		}
+		if action == ACLNone || entry.Action == action {
+			entries = append(entries, entry)
+		}
+	}
+	a.mux.Unlock()
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].CIDR < entries[j].CIDR
+	})
+	return entries
+}
+
+// Prune drops expired entries from memory and, unless it is read-only,
+// from storage. Storage is written without holding the lock, so lookups
+// and listings do not wait on it.
+func (a *ACL) Prune(ctx context.Context) error {
+	now := time.Now()
+
+	a.mux.Lock()
+	var expired []string
+	for cidr, entry := range a.entries {
+		if entry.expired(now) {
+			expired = append(expired, cidr)
+			delete(a.entries, cidr)
+		}
+	}
+	if len(expired) > 0 {
+		a.rebuildLocked()
+	}
+	a.mux.Unlock()
+
+	if storage, ok := a.storage.(interface{ ReadOnly() bool }); ok && storage.ReadOnly() {
+		return nil
+	}
+	for _, cidr := range expired {
+		a.mux.Lock()
+		_, readded := a.entries[cidr]
+		a.mux.Unlock()
+		if readded {
+			continue
+		}
+		if err := a.storage.DeleteACLEntry(ctx, cidr); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (a *ACL) rebuildLocked() {
+	trie := newPrefixTrie[*ACLEntry]()
+	for cidr, entry := range a.entries {
+		prefix, err := parsePrefix(cidr)
+		if err != nil {
+			log.Printf("Skipping invalid ACL entry %s: %v", cidr, err)
+			continue
+		}
+		trie.insert(prefix, entry)
+	}
+	a.trie.Store(trie)
+}
@@ -0,0 +1,82 @@
+package ratelimiter
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// countingACLStorage counts deletes and can pose as read-only.
+type countingACLStorage struct {
+	ACLStorage
+	readOnly bool
+	deletes  int
+}
+
+func (s *countingACLStorage) DeleteACLEntry(ctx context.Context, cidr string) error {
+	if s.readOnly {
+		return ErrReadOnlyStorage
+	}
+	s.deletes++
+	return s.ACLStorage.DeleteACLEntry(ctx, cidr)
+}
+
+func (s *countingACLStorage) ReadOnly() bool {
+	return s.readOnly
+}
+
+func newTestACL(t *testing.T, readOnly bool) (*ACL, *countingACLStorage) {
+	t.Helper()
+	bolt, err := NewBoltStorage(filepath.Join(t.TempDir(), "acl.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { bolt.Close() })
+
+	storage := &countingACLStorage{ACLStorage: bolt}
+	acl := NewACL(storage)
+	past := time.Now().Add(-time.Minute)
+	for _, entry := range []*ACLEntry{
+		{CIDR: "192.0.2.0/24", Action: ACLDeny},
+		{CIDR: "198.51.100.0/24", Action: ACLDeny, ExpiresAt: &past},
+	} {
+		if err := acl.Add(context.Background(), entry); err != nil {
+			t.Fatal(err)
+		}
+	}
+	storage.readOnly = readOnly
+	return acl, storage
+}
+
+func TestACLListDoesNotWrite(t *testing.T) {
+	acl, storage := newTestACL(t, false)
+	entries := acl.List(ACLNone)
+	if len(entries) != 1 || entries[0].CIDR != "192.0.2.0/24" {
+		t.Fatalf("List = %+v, want only the unexpired entry", entries)
+	}
+	if storage.deletes != 0 {
+		t.Fatalf("List deleted %d entries from storage", storage.deletes)
+	}
+}
+
+func TestACLPrune(t *testing.T) {
+	acl, storage := newTestACL(t, false)
+	if err := acl.Prune(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if storage.deletes != 1 {
+		t.Fatalf("Prune deleted %d entries, want 1", storage.deletes)
+	}
+	if err := acl.Prune(context.Background()); err != nil || storage.deletes != 1 {
+		t.Fatalf("second Prune = %v with %d deletes, want nothing more to delete", err, storage.deletes)
+	}
+
+	acl, _ = newTestACL(t, true)
+	if err := acl.Prune(context.Background()); err != nil {
+		t.Fatalf("Prune on read-only storage: %v", err)
+	}
+	if len(acl.List(ACLNone)) != 1 {
+		t.Fatal("expired entry still listed after Prune")
+	}
+}
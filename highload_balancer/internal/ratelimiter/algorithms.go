@@ -0,0 +1,430 @@
+package ratelimiter
+
+import (
+	"log"
+	"math"
+	"sync"
+	"time"
+)
+
+type Limiter interface {
+	// AllowN admits a request costing n units if the whole cost fits and
+	// reports the budget left afterwards.
+	AllowN(now time.Time, n int) (bool, float64)
+	// Delay estimates how long until a request costing n would be admitted.
+	Delay(now time.Time, n int) time.Duration
+	// Refund gives back n units taken by an admitted request that was
+	// turned away later on.
+	Refund(now time.Time, n int)
+	Algorithm() AlgorithmType
+	Reconfigure(capacity, rate float64, now time.Time)
+	// Full reports whether the limiter is back in its initial state.
+	Full(now time.Time) bool
+	// Inspect reports the budget available at now without consuming it.
+	Inspect(now time.Time) LimiterState
+}
+
+// LimiterState is a snapshot of a limiter. LastRefill is only known for
+// token buckets.
+type LimiterState struct {
+	Tokens     float64
+	LastRefill time.Time
+}
+
+func NewLimiter(algorithm AlgorithmType, capacity, rate float64, now time.Time) Limiter {
+	switch algorithm {
+	case TokenBucketAlgorithm, "":
+		return newTokenBucket(capacity, rate, now)
+	case SlidingWindowCounterAlgorithm:
+		return newSlidingWindowCounter(capacity, rate, now)
+	case SlidingWindowLogAlgorithm:
+		return newSlidingWindowLog(capacity, rate)
+	case GCRAAlgorithm:
+		return newGCRA(capacity, rate, now)
+	default:
+		log.Printf("Unknown rate limit algorithm %s, defaulting to token-bucket", algorithm)
+		return newTokenBucket(capacity, rate, now)
+	}
+}
+
+// windowFor returns the period in which capacity requests are admitted
+// at the given rate, e.g. capacity 60 at rate 1 is 60 requests per minute.
+func windowFor(capacity, rate float64) time.Duration {
+	if rate <= 0 {
+		return time.Duration(math.MaxInt64)
+	}
+	return time.Duration(capacity / rate * float64(time.Second))
+}
+
+// SlidingWindowCounter approximates a rolling window by weighting the
+// previous fixed window's count by how much of it still overlaps.
+type SlidingWindowCounter struct {
+	limit  float64
+	window time.Duration
+	start  time.Time
+	curr   int
+	prev   int
+	mux    sync.Mutex
+}
+
+func newSlidingWindowCounter(capacity, rate float64, now time.Time) *SlidingWindowCounter {
+	return &SlidingWindowCounter{
+		limit:  capacity,
+		window: windowFor(capacity, rate),
+		start:  now,
+	}
+}
+
+func (s *SlidingWindowCounter) advance(now time.Time) time.Duration {
+	if s.window <= 0 {
+		// Only a zero capacity, which admits nothing, or a rate too high
+		// to measure gives an empty window; either way there is nothing
+		// to remember.
+		s.prev, s.curr = 0, 0
+		s.start = now
+		return 0
+	}
+
+	elapsed := now.Sub(s.start)
+	if elapsed < s.window {
+		return elapsed
+	}
+
+	passed := elapsed / s.window
+	if passed == 1 {
+		s.prev = s.curr
+	} else {
+		s.prev = 0
+	}
+	s.curr = 0
+	s.start = s.start.Add(passed * s.window)
+	return now.Sub(s.start)
+}
+
+// weight is the share of the previous window that still overlaps the
+// rolling one.
+func (s *SlidingWindowCounter) weight(elapsed time.Duration) float64 {
+	if s.window <= 0 {
+		return 0
+	}
+	return 1 - float64(elapsed)/float64(s.window)
+}
+
+func (s *SlidingWindowCounter) Algorithm() AlgorithmType {
+	return SlidingWindowCounterAlgorithm
+}
+
+func (s *SlidingWindowCounter) Reconfigure(capacity, rate float64, now time.Time) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	s.advance(now)
+	s.limit = capacity
+	s.window = windowFor(capacity, rate)
+}
+
+func (s *SlidingWindowCounter) Full(now time.Time) bool {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	s.advance(now)
+	return s.curr == 0 && s.prev == 0
+}
+
+const never = time.Duration(math.MaxInt64)
+
+func (s *SlidingWindowCounter) Inspect(now time.Time) LimiterState {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	elapsed := s.advance(now)
+	weight := s.weight(elapsed)
+	return LimiterState{Tokens: max(s.limit-float64(s.prev)*weight-float64(s.curr), 0)}
+}
+
+func (s *SlidingWindowCounter) Delay(now time.Time, n int) time.Duration {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	elapsed := s.advance(now)
+	weight := s.weight(elapsed)
+	excess := float64(s.prev)*weight + float64(s.curr) + float64(n) - s.limit
+	if excess <= 0 {
+		return 0
+	}
+	if float64(n) > s.limit {
+		return never
+	}
+	// Within this window only the previous window's share shrinks.
+	if s.prev > 0 && float64(s.curr)+float64(n) <= s.limit {
+		return time.Duration(excess / float64(s.prev) * float64(s.window))
+	}
+	return s.window - elapsed
+}
+
+func (s *SlidingWindowCounter) AllowN(now time.Time, n int) (bool, float64) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	elapsed := s.advance(now)
+	weight := s.weight(elapsed)
+	used := float64(s.prev)*weight + float64(s.curr)
+	if used+float64(n) > s.limit {
+		return false, max(s.limit-used, 0)
+	}
+	s.curr += n
+	return true, s.limit - used - float64(n)
+}
+
+func (s *SlidingWindowCounter) Refund(now time.Time, n int) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	s.advance(now)
+	s.curr = max(s.curr-n, 0)
+}
+
+// SlidingWindowLog keeps the timestamp of every admitted request in the
+// window, which is exact at the cost of one entry per request of capacity.
+// The log grows as requests arrive, so a large capacity costs memory only
+// for clients that actually use it.
+type SlidingWindowLog struct {
+	limit  int
+	window time.Duration
+	// log is a ring buffer of admission times, oldest at head.
+	log  []time.Time
+	head int
+	size int
+	mux  sync.Mutex
+}
+
+func newSlidingWindowLog(capacity, rate float64) *SlidingWindowLog {
+	return &SlidingWindowLog{
+		limit:  logSize(capacity),
+		window: windowFor(capacity, rate),
+	}
+}
+
+func logSize(capacity float64) int {
+	return int(max(math.Floor(capacity), 0))
+}
+
+// at returns the i-th oldest entry.
+func (s *SlidingWindowLog) at(i int) time.Time {
+	return s.log[(s.head+i)%len(s.log)]
+}
+
+// resize moves the log into a ring of n slots, keeping the most recent
+// entries that fit.
+func (s *SlidingWindowLog) resize(n int) {
+	log := make([]time.Time, n)
+	keep := min(s.size, n)
+	for i := 0; i < keep; i++ {
+		log[i] = s.at(s.size - keep + i)
+	}
+	s.log = log
+	s.head = 0
+	s.size = keep
+}
+
+func (s *SlidingWindowLog) expire(now time.Time) {
+	for s.size > 0 && now.Sub(s.log[s.head]) >= s.window {
+		s.head = (s.head + 1) % len(s.log)
+		s.size--
+	}
+}
+
+func (s *SlidingWindowLog) Algorithm() AlgorithmType {
+	return SlidingWindowLogAlgorithm
+}
+
+// Reconfigure keeps the most recent entries that still fit the new limit
+// so the client cannot burst past it.
+func (s *SlidingWindowLog) Reconfigure(capacity, rate float64, now time.Time) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	s.window = windowFor(capacity, rate)
+	s.expire(now)
+
+	s.limit = logSize(capacity)
+	if len(s.log) > s.limit {
+		s.resize(s.limit)
+	}
+}
+
+func (s *SlidingWindowLog) Full(now time.Time) bool {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	s.expire(now)
+	return s.size == 0
+}
+
+func (s *SlidingWindowLog) Inspect(now time.Time) LimiterState {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	s.expire(now)
+	return LimiterState{Tokens: float64(s.limit - s.size)}
+}
+
+func (s *SlidingWindowLog) Delay(now time.Time, n int) time.Duration {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	s.expire(now)
+	if n > s.limit {
+		return never
+	}
+	free := s.limit - s.size
+	if n <= free {
+		return 0
+	}
+	// Wait for the (n-free)th oldest entry to leave the window.
+	return s.at(n - free - 1).Add(s.window).Sub(now)
+}
+
+func (s *SlidingWindowLog) AllowN(now time.Time, n int) (bool, float64) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	s.expire(now)
+	if s.size+n > s.limit {
+		return false, float64(s.limit - s.size)
+	}
+	if s.size+n > len(s.log) {
+		s.resize(min(max(s.size+n, 2*len(s.log)), s.limit))
+	}
+	for i := 0; i < n; i++ {
+		s.log[(s.head+s.size)%len(s.log)] = now
+		s.size++
+	}
+	return true, float64(s.limit - s.size)
+}
+
+// Refund drops the n most recent entries.
+func (s *SlidingWindowLog) Refund(now time.Time, n int) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	s.expire(now)
+	s.size = max(s.size-n, 0)
+}
+
+// GCRA tracks a single theoretical arrival time instead of a token count.
+// It admits the same traffic as a token bucket but needs no refill step.
+type GCRA struct {
+	emission  float64
+	tolerance float64
+	tat       float64
+	base      time.Time
+	used      float64
+	capacity  float64
+	mux       sync.Mutex
+}
+
+func newGCRA(capacity, rate float64, now time.Time) *GCRA {
+	g := &GCRA{base: now}
+	g.setLimits(capacity, rate)
+	return g
+}
+
+func (g *GCRA) setLimits(capacity, rate float64) {
+	g.capacity = capacity
+	g.emission = 0
+	g.tolerance = 0
+	if rate > 0 {
+		g.emission = 1 / rate
+		g.tolerance = g.emission * capacity
+	}
+}
+
+func (g *GCRA) Algorithm() AlgorithmType {
+	return GCRAAlgorithm
+}
+
+// Reconfigure keeps the theoretical arrival time but never lets it lag
+// behind now by more than the new burst, mirroring a token bucket clamp.
+func (g *GCRA) Reconfigure(capacity, rate float64, now time.Time) {
+	g.mux.Lock()
+	defer g.mux.Unlock()
+
+	g.setLimits(capacity, rate)
+	t := now.Sub(g.base).Seconds()
+	g.tat = min(g.tat, t+g.tolerance)
+}
+
+func (g *GCRA) Full(now time.Time) bool {
+	g.mux.Lock()
+	defer g.mux.Unlock()
+
+	if g.emission == 0 {
+		return g.used == 0
+	}
+	return g.tat <= now.Sub(g.base).Seconds()
+}
+
+func (g *GCRA) Inspect(now time.Time) LimiterState {
+	g.mux.Lock()
+	defer g.mux.Unlock()
+
+	if g.emission == 0 {
+		return LimiterState{Tokens: g.capacity - g.used}
+	}
+	t := now.Sub(g.base).Seconds()
+	return LimiterState{Tokens: max(g.tolerance-(max(g.tat, t)-t), 0) / g.emission}
+}
+
+func (g *GCRA) Delay(now time.Time, n int) time.Duration {
+	g.mux.Lock()
+	defer g.mux.Unlock()
+
+	if g.emission == 0 {
+		if g.used+float64(n) > g.capacity {
+			return never
+		}
+		return 0
+	}
+
+	t := now.Sub(g.base).Seconds()
+	tat := max(g.tat, t) + float64(n)*g.emission
+	if float64(n)*g.emission > g.tolerance {
+		return never
+	}
+	return time.Duration(max(tat-t-g.tolerance, 0) * float64(time.Second))
+}
+
+func (g *GCRA) AllowN(now time.Time, n int) (bool, float64) {
+	g.mux.Lock()
+	defer g.mux.Unlock()
+
+	if g.emission == 0 {
+		// Without a rate the budget never replenishes.
+		if g.used+float64(n) > g.capacity {
+			return false, g.capacity - g.used
+		}
+		g.used += float64(n)
+		return true, g.capacity - g.used
+	}
+
+	t := now.Sub(g.base).Seconds()
+	base := max(g.tat, t)
+	tat := base + float64(n)*g.emission
+	if tat-t > g.tolerance {
+		return false, max(g.tolerance-(base-t), 0) / g.emission
+	}
+	g.tat = tat
+	return true, (g.tolerance - (tat - t)) / g.emission
+}
+
+func (g *GCRA) Refund(now time.Time, n int) {
+	g.mux.Lock()
+	defer g.mux.Unlock()
+
+	if g.emission == 0 {
+		g.used = max(g.used-float64(n), 0)
+		return
+	}
+	g.tat -= float64(n) * g.emission
+}
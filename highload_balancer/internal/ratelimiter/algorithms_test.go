@@ -0,0 +1,123 @@
+package ratelimiter
+
+import (
+	"testing"
+	"time"
+)
+
+var algorithms = []AlgorithmType{
+	TokenBucketAlgorithm,
+	SlidingWindowCounterAlgorithm,
+	SlidingWindowLogAlgorithm,
+	GCRAAlgorithm,
+}
+
+func TestZeroLimits(t *testing.T) {
+	now := time.Now()
+	for _, algorithm := range algorithms {
+		for _, limits := range [][2]float64{{0, 10}, {0, 0}} {
+			l := NewLimiter(algorithm, limits[0], limits[1], now)
+			for i := 0; i < 3; i++ {
+				at := now.Add(time.Duration(i) * time.Second)
+				if allowed, _ := l.AllowN(at, 1); allowed {
+					t.Errorf("%s %v: request admitted with zero capacity", algorithm, limits)
+				}
+				if d := l.Delay(at, 1); d != never {
+					t.Errorf("%s %v: Delay = %v, want never", algorithm, limits, d)
+				}
+				if state := l.Inspect(at); state.Tokens != 0 {
+					t.Errorf("%s %v: Inspect = %+v, want no tokens", algorithm, limits, state)
+				}
+			}
+		}
+
+		// Without a rate the initial budget is all there is.
+		l := NewLimiter(algorithm, 2, 0, now)
+		for i := 0; i < 2; i++ {
+			if allowed, _ := l.AllowN(now.Add(time.Duration(i)*time.Hour), 1); !allowed {
+				t.Errorf("%s: request %d rejected within capacity", algorithm, i)
+			}
+		}
+		if allowed, _ := l.AllowN(now.Add(3*time.Hour), 1); allowed {
+			t.Errorf("%s: budget replenished without a rate", algorithm)
+		}
+	}
+}
+
+func TestReconfigureToZero(t *testing.T) {
+	now := time.Now()
+	for _, algorithm := range algorithms {
+		l := NewLimiter(algorithm, 10, 10, now)
+		l.AllowN(now, 3)
+		l.Reconfigure(0, 10, now)
+		if allowed, _ := l.AllowN(now.Add(time.Second), 1); allowed {
+			t.Errorf("%s: request admitted after capacity set to zero", algorithm)
+		}
+		l.Reconfigure(5, 5, now.Add(time.Second))
+		if allowed, _ := l.AllowN(now.Add(10*time.Second), 5); !allowed {
+			t.Errorf("%s: full capacity rejected after capacity restored", algorithm)
+		}
+	}
+}
+
+func TestSlidingWindowLogGrowsLazily(t *testing.T) {
+	now := time.Now()
+	l := newSlidingWindowLog(1e6, 1e5)
+	if len(l.log) != 0 {
+		t.Fatalf("new log has %d slots, want 0", len(l.log))
+	}
+
+	for i := 0; i < 100; i++ {
+		if allowed, _ := l.AllowN(now, 1); !allowed {
+			t.Fatalf("request %d rejected", i)
+		}
+	}
+	if len(l.log) > 256 {
+		t.Fatalf("log has %d slots after 100 requests", len(l.log))
+	}
+	if state := l.Inspect(now); state.Tokens != 1e6-100 {
+		t.Fatalf("Inspect = %v tokens, want %v", state.Tokens, 1e6-100)
+	}
+
+	// The limit still holds once the log has grown to it.
+	small := newSlidingWindowLog(3, 1)
+	for i := 0; i < 3; i++ {
+		small.AllowN(now.Add(time.Duration(i)*time.Millisecond), 1)
+	}
+	if allowed, _ := small.AllowN(now.Add(time.Second), 1); allowed {
+		t.Fatal("fourth request admitted within the window")
+	}
+	if d := small.Delay(now.Add(time.Second), 1); d != 2*time.Second {
+		t.Fatalf("Delay = %v, want 2s", d)
+	}
+	if allowed, _ := small.AllowN(now.Add(3*time.Second), 1); !allowed {
+		t.Fatal("request rejected after the oldest entry left the window")
+	}
+}
+
+func TestRefund(t *testing.T) {
+	now := time.Now()
+	for _, algorithm := range algorithms {
+		l := NewLimiter(algorithm, 5, 1, now)
+		if allowed, _ := l.AllowN(now, 5); !allowed {
+			t.Fatalf("%s: full capacity rejected", algorithm)
+		}
+		l.Refund(now, 3)
+		if state := l.Inspect(now); state.Tokens != 3 {
+			t.Errorf("%s: %v tokens after refund, want 3", algorithm, state.Tokens)
+		}
+		if allowed, _ := l.AllowN(now, 3); !allowed {
+			t.Errorf("%s: refunded tokens rejected", algorithm)
+		}
+		if allowed, _ := l.AllowN(now, 1); allowed {
+			t.Errorf("%s: admitted past capacity after refund", algorithm)
+		}
+
+		// Refunds never raise the budget above capacity.
+		l = NewLimiter(algorithm, 5, 1, now)
+		l.Refund(now, 3)
+		if allowed, _ := l.AllowN(now, 6); allowed {
+			t.Errorf("%s: refund raised the budget above capacity", algorithm)
+		}
+	}
+}
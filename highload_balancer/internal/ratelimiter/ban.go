@@ -0,0 +1,293 @@
+package ratelimiter
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/se1y4/highload-balancer/internal/metrics"
+)
+
+var ErrBanNotFound = errors.New("ban not found")
+
+type Ban struct {
+	ClientID    string    `json:"client_id"`
+	Offenses    int       `json:"offenses"`
+	BannedAt    time.Time `json:"banned_at"`
+	BannedUntil time.Time `json:"banned_until"`
+}
+
+// BanStorage shares bans between instances. Only active bans are stored;
+// rejection counts stay local.
+type BanStorage interface {
+	SaveBan(context.Context, *Ban) error
+	DeleteBan(ctx context.Context, clientID string) error
+	GetActiveBans(context.Context) ([]*Ban, error)
+}
+
+// BanConfig bans a client for Duration after Threshold rejections within
+// Window. Each repeat offense multiplies the ban by Multiplier, up to
+// MaxDuration; a client with no ban for ForgetAfter starts over.
+type BanConfig struct {
+	Threshold    int
+	Window       time.Duration
+	Duration     time.Duration
+	MaxDuration  time.Duration
+	Multiplier   float64
+	ForgetAfter  time.Duration
+	SyncInterval time.Duration
+}
+
+type offender struct {
+	rejections  int
+	windowStart time.Time
+	offenses    int
+	bannedAt    time.Time
+	bannedUntil time.Time
+	// shared is set once the current ban has been seen in storage, so
+	// its later absence means another instance lifted it.
+	shared bool
+}
+
+// BanTracker temporarily bans clients that keep sending requests after
+// being rejected, so they can be turned away before any limiter runs.
+type BanTracker struct {
+	config    *BanConfig
+	storage   BanStorage
+	offenders map[string]*offender
+	mux       sync.Mutex
+	stopChan  chan struct{}
+	doneChan  chan struct{}
+}
+
+// NewBanTracker shares bans through storage when it is not nil.
+func NewBanTracker(config *BanConfig, storage BanStorage) *BanTracker {
+	if config.Threshold <= 0 {
+		config.Threshold = 100
+	}
+	if config.Window <= 0 {
+		config.Window = 10 * time.Second
+	}
+	if config.Duration <= 0 {
+		config.Duration = time.Minute
+	}
+	if config.MaxDuration < config.Duration {
+		config.MaxDuration = 24 * time.Hour
+	}
+	if config.Multiplier < 1 {
+		config.Multiplier = 2
+	}
+	if config.ForgetAfter <= 0 {
+		config.ForgetAfter = 24 * time.Hour
+	}
+	if config.SyncInterval <= 0 {
+		config.SyncInterval = 5 * time.Second
+	}
+
+	bt := &BanTracker{
+		config:    config,
+		storage:   storage,
+		offenders: make(map[string]*offender),
+		stopChan:  make(chan struct{}),
+		doneChan:  make(chan struct{}),
+	}
+	bt.sync()
+	go bt.syncLoop()
+	return bt
+}
+
+func (bt *BanTracker) Stop() {
+	close(bt.stopChan)
+	<-bt.doneChan
+}
+
+// Banned returns when the client's ban ends, if it is banned.
+func (bt *BanTracker) Banned(clientID string, now time.Time) (time.Time, bool) {
+	bt.mux.Lock()
+	defer bt.mux.Unlock()
+
+	o, exists := bt.offenders[clientID]
+	if !exists || !now.Before(o.bannedUntil) {
+		return time.Time{}, false
+	}
+	return o.bannedUntil, true
+}
+
+// RecordRejection counts a rejected request and bans the client once it
+// crosses the threshold.
+func (bt *BanTracker) RecordRejection(clientID string, now time.Time) {
+	bt.mux.Lock()
+
+	o, exists := bt.offenders[clientID]
+	if !exists {
+		o = &offender{windowStart: now}
+		bt.offenders[clientID] = o
+	}
+	if now.Before(o.bannedUntil) {
+		bt.mux.Unlock()
+		return
+	}
+	if !o.bannedUntil.IsZero() && now.Sub(o.bannedUntil) >= bt.config.ForgetAfter {
+		o.offenses = 0
+	}
+	if now.Sub(o.windowStart) >= bt.config.Window {
+		o.windowStart = now
+		o.rejections = 0
+	}
+	o.rejections++
+	if o.rejections < bt.config.Threshold {
+		bt.mux.Unlock()
+		return
+	}
+
+	o.rejections = 0
+	o.offenses++
+	o.bannedAt = now
+	o.bannedUntil = now.Add(bt.banDuration(o.offenses))
+	o.shared = false
+	ban := o.ban(clientID)
+	bt.mux.Unlock()
+
+	metrics.Bans.Add(1)
+	log.Printf("Client %s banned until %s after %d offenses", clientID, ban.BannedUntil.Format(time.RFC3339), ban.Offenses)
+	if bt.storage != nil {
+		if err := bt.storage.SaveBan(context.Background(), ban); err != nil {
+			log.Printf("Failed to save ban for %s: %v", clientID, err)
+		}
+	}
+}
+
+func (bt *BanTracker) banDuration(offenses int) time.Duration {
+	d := float64(bt.config.Duration)
+	for i := 1; i < offenses && d < float64(bt.config.MaxDuration); i++ {
+		d *= bt.config.Multiplier
+	}
+	return min(time.Duration(d), bt.config.MaxDuration)
+}
+
+func (o *offender) ban(clientID string) *Ban {
+	return &Ban{
+		ClientID:    clientID,
+		Offenses:    o.offenses,
+		BannedAt:    o.bannedAt,
+		BannedUntil: o.bannedUntil,
+	}
+}
+
+func (bt *BanTracker) List() []*Ban {
+	now := time.Now()
+
+	bt.mux.Lock()
+	bans := make([]*Ban, 0)
+	for clientID, o := range bt.offenders {
+		if now.Before(o.bannedUntil) {
+			bans = append(bans, o.ban(clientID))
+		}
+	}
+	bt.mux.Unlock()
+
+	sort.Slice(bans, func(i, j int) bool {
+		return bans[i].BannedUntil.Before(bans[j].BannedUntil)
+	})
+	return bans
+}
+
+// Lift ends a ban early. The offense count is kept, so a client that
+// goes straight back to hammering gets the next, longer ban.
+func (bt *BanTracker) Lift(ctx context.Context, clientID string) error {
+	now := time.Now()
+
+	bt.mux.Lock()
+	o, exists := bt.offenders[clientID]
+	if !exists || !now.Before(o.bannedUntil) {
+		bt.mux.Unlock()
+		return ErrBanNotFound
+	}
+	o.bannedUntil = now
+	o.rejections = 0
+	bt.mux.Unlock()
+
+	if bt.storage != nil {
+		return bt.storage.DeleteBan(ctx, clientID)
+	}
+	return nil
+}
+
+func (bt *BanTracker) syncLoop() {
+	defer close(bt.doneChan)
+
+	ticker := time.NewTicker(bt.config.SyncInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			bt.sync()
+		case <-bt.stopChan:
+			return
+		}
+	}
+}
+
+// sync forgets idle offenders and, with shared storage, mirrors the bans
+// other instances made or lifted.
+func (bt *BanTracker) sync() {
+	var (
+		shared map[string]*Ban
+		err    error
+	)
+	if bt.storage != nil {
+		shared, err = bt.activeBans()
+		if err != nil {
+			log.Printf("Failed to load bans: %v", err)
+		}
+	}
+
+	now := time.Now()
+	bt.mux.Lock()
+	defer bt.mux.Unlock()
+
+	for clientID, o := range bt.offenders {
+		banned := now.Before(o.bannedUntil)
+		if shared != nil && banned && o.shared {
+			if _, exists := shared[clientID]; !exists {
+				o.bannedUntil = now
+				banned = false
+			}
+		}
+		idle := now.Sub(o.windowStart) >= bt.config.Window
+		forgotten := o.bannedUntil.IsZero() || now.Sub(o.bannedUntil) >= bt.config.ForgetAfter
+		if !banned && idle && forgotten {
+			delete(bt.offenders, clientID)
+		}
+	}
+
+	for clientID, ban := range shared {
+		o, exists := bt.offenders[clientID]
+		if !exists {
+			o = &offender{windowStart: now}
+			bt.offenders[clientID] = o
+		}
+		if !ban.BannedUntil.Before(o.bannedUntil) {
+			o.offenses = max(o.offenses, ban.Offenses)
+			o.bannedAt = ban.BannedAt
+			o.bannedUntil = ban.BannedUntil
+			o.shared = true
+		}
+	}
+}
+
+func (bt *BanTracker) activeBans() (map[string]*Ban, error) {
+	bans, err := bt.storage.GetActiveBans(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	active := make(map[string]*Ban, len(bans))
+	for _, ban := range bans {
+		active[ban.ClientID] = ban
+	}
+	return active, nil
+}
@@ -0,0 +1,308 @@
+package ratelimiter
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	clientsBucket   = []byte("clients")
+	plansBucket     = []byte("plans")
+	overridesBucket = []byte("limit_overrides")
+	aclBucket       = []byte("acl_entries")
+	usageBucket     = []byte("client_usage")
+)
+
+// BoltStorage keeps clients, plans, overrides, ACL entries and quota
+// usage in a single embedded bbolt file, for deployments without
+// PostgreSQL. The file can only be open in one process at a time.
+type BoltStorage struct {
+	db *bolt.DB
+}
+
+func NewBoltStorage(path string) (*BoltStorage, error) {
+	db, err := bolt.Open(path, 0o600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open bolt storage: %w", err)
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{clientsBucket, plansBucket, overridesBucket, aclBucket, usageBucket} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to init bolt storage: %w", err)
+	}
+	return &BoltStorage{db: db}, nil
+}
+
+func boltGet(b *bolt.Bucket, key []byte, v any) (bool, error) {
+	data := b.Get(key)
+	if data == nil {
+		return false, nil
+	}
+	return true, json.Unmarshal(data, v)
+}
+
+func boltPut(b *bolt.Bucket, key []byte, v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return b.Put(key, data)
+}
+
+// SaveClient inserts or replaces a client, keeping the creation time of
+// an existing one.
+func (s *BoltStorage) SaveClient(ctx context.Context, client *ClientConfig) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(clientsBucket)
+		saved := *client
+		var existing ClientConfig
+		found, err := boltGet(b, []byte(client.ClientID), &existing)
+		if err != nil {
+			return err
+		}
+		if found {
+			saved.CreatedAt = existing.CreatedAt
+		}
+		return boltPut(b, []byte(client.ClientID), &saved)
+	})
+}
+
+func (s *BoltStorage) DeleteClient(ctx context.Context, clientID string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		// Overrides go with their client, as with a foreign key.
+		overrides := tx.Bucket(overridesBucket)
+		var stale [][]byte
+		err := overrides.ForEach(func(k, v []byte) error {
+			var o Override
+			if err := json.Unmarshal(v, &o); err != nil {
+				return err
+			}
+			if o.ClientID == clientID {
+				stale = append(stale, k)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, k := range stale {
+			if err := overrides.Delete(k); err != nil {
+				return err
+			}
+		}
+		return tx.Bucket(clientsBucket).Delete([]byte(clientID))
+	})
+}
+
+func (s *BoltStorage) GetClient(ctx context.Context, clientID string) (*ClientConfig, error) {
+	var (
+		client ClientConfig
+		found  bool
+	)
+	err := s.db.View(func(tx *bolt.Tx) error {
+		var err error
+		found, err = boltGet(tx.Bucket(clientsBucket), []byte(clientID), &client)
+		return err
+	})
+	if err != nil || !found {
+		return nil, err
+	}
+	return &client, nil
+}
+
+func (s *BoltStorage) GetAllClients(ctx context.Context) (map[string]*ClientConfig, error) {
+	clients := make(map[string]*ClientConfig)
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(clientsBucket).ForEach(func(k, v []byte) error {
+			var client ClientConfig
+			if err := json.Unmarshal(v, &client); err != nil {
+				return err
+			}
+			clients[client.ClientID] = &client
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return clients, nil
+}
+
+func (s *BoltStorage) SavePlan(ctx context.Context, plan *Plan) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(plansBucket)
+		now := time.Now()
+		saved := *plan
+		saved.CreatedAt, saved.LastUpdated = now, now
+
+		var existing Plan
+		found, err := boltGet(b, []byte(plan.Name), &existing)
+		if err != nil {
+			return err
+		}
+		if found {
+			saved.CreatedAt = existing.CreatedAt
+		}
+		return boltPut(b, []byte(plan.Name), &saved)
+	})
+}
+
+func (s *BoltStorage) DeletePlan(ctx context.Context, name string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(plansBucket).Delete([]byte(name))
+	})
+}
+
+func (s *BoltStorage) GetAllPlans(ctx context.Context) (map[string]*Plan, error) {
+	plans := make(map[string]*Plan)
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(plansBucket).ForEach(func(k, v []byte) error {
+			var plan Plan
+			if err := json.Unmarshal(v, &plan); err != nil {
+				return err
+			}
+			plans[plan.Name] = &plan
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return plans, nil
+}
+
+func overrideKey(id int64) []byte {
+	return binary.BigEndian.AppendUint64(nil, uint64(id))
+}
+
+// SaveOverride stores a new override and sets its ID.
+func (s *BoltStorage) SaveOverride(ctx context.Context, o *Override) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(overridesBucket)
+		if tx.Bucket(clientsBucket).Get([]byte(o.ClientID)) == nil {
+			return fmt.Errorf("client %s does not exist", o.ClientID)
+		}
+		id, err := b.NextSequence()
+		if err != nil {
+			return err
+		}
+		o.ID = int64(id)
+		return boltPut(b, overrideKey(o.ID), o)
+	})
+}
+
+func (s *BoltStorage) DeleteOverride(ctx context.Context, id int64) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(overridesBucket).Delete(overrideKey(id))
+	})
+}
+
+func (s *BoltStorage) GetAllOverrides(ctx context.Context) ([]*Override, error) {
+	var overrides []*Override
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(overridesBucket).ForEach(func(k, v []byte) error {
+			var o Override
+			if err := json.Unmarshal(v, &o); err != nil {
+				return err
+			}
+			overrides = append(overrides, &o)
+			return nil
+		})
+	})
+	return overrides, err
+}
+
+func (s *BoltStorage) SaveACLEntry(ctx context.Context, entry *ACLEntry) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return boltPut(tx.Bucket(aclBucket), []byte(entry.CIDR), entry)
+	})
+}
+
+func (s *BoltStorage) DeleteACLEntry(ctx context.Context, cidr string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(aclBucket).Delete([]byte(cidr))
+	})
+}
+
+func (s *BoltStorage) GetACLEntries(ctx context.Context) ([]*ACLEntry, error) {
+	now := time.Now()
+	var entries []*ACLEntry
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(aclBucket).ForEach(func(k, v []byte) error {
+			var entry ACLEntry
+			if err := json.Unmarshal(v, &entry); err != nil {
+				return err
+			}
+			if !entry.expired(now) {
+				entries = append(entries, &entry)
+			}
+			return nil
+		})
+	})
+	return entries, err
+}
+
+func usageRecordKey(rec UsageRecord) []byte {
+	return []byte(rec.ClientID + "\x00" + string(rec.Period) + "\x00" + strconv.FormatInt(rec.PeriodStart.Unix(), 10))
+}
+
+func (s *BoltStorage) LoadUsage(ctx context.Context, since time.Time) ([]UsageRecord, error) {
+	var records []UsageRecord
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(usageBucket).ForEach(func(k, v []byte) error {
+			var rec UsageRecord
+			if err := json.Unmarshal(v, &rec); err != nil {
+				return err
+			}
+			if !rec.PeriodStart.Before(since) {
+				records = append(records, rec)
+			}
+			return nil
+		})
+	})
+	return records, err
+}
+
+// AddUsage increments usage counters in one transaction and returns the
+// resulting totals.
+func (s *BoltStorage) AddUsage(ctx context.Context, records []UsageRecord) ([]UsageRecord, error) {
+	totals := make([]UsageRecord, 0, len(records))
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(usageBucket)
+		for _, rec := range records {
+			key := usageRecordKey(rec)
+			var total UsageRecord
+			if _, err := boltGet(b, key, &total); err != nil {
+				return err
+			}
+			rec.Count += total.Count
+			if err := boltPut(b, key, &rec); err != nil {
+				return err
+			}
+			totals = append(totals, rec)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return totals, nil
+}
+
+func (s *BoltStorage) Close() error {
+	return s.db.Close()
+}
@@ -0,0 +1,30 @@
+package ratelimiter_test
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/se1y4/highload-balancer/internal/ratelimiter"
+	"github.com/se1y4/highload-balancer/internal/ratelimiter/storagetest"
+)
+
+func TestBoltStorage(t *testing.T) {
+	storagetest.Run(t, func(t *testing.T, clients []*ratelimiter.ClientConfig) ratelimiter.ClientStorage {
+		s, err := ratelimiter.NewBoltStorage(filepath.Join(t.TempDir(), "clients.db"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		seed(t, s, clients)
+		return s
+	})
+}
+
+func seed(t *testing.T, s ratelimiter.ClientStorage, clients []*ratelimiter.ClientConfig) {
+	t.Helper()
+	for _, client := range clients {
+		if err := s.SaveClient(context.Background(), client); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
@@ -1,6 +1,7 @@
 package ratelimiter
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"log"
@@ -10,10 +11,27 @@ import (
 )
 
 type ClientManager struct {
-	storage     ClientStorage
-	rateLimiter *RateLimiter
-	clients     map[string]*ClientConfig
-	mux         sync.RWMutex
+	storage         ClientStorage
+	planStorage     PlanStorage
+	overrideStorage OverrideStorage
+	acl             *ACL
+	rateLimiter     *RateLimiter
+	clients         map[string]*ClientConfig
+	// effective holds each client's config with its plan and active
+	// override applied; it is what the request path reads.
+	effective map[string]*ClientConfig
+	patterns  *clientPatterns
+	plans     map[string]*Plan
+	overrides map[int64]*Override
+	active    map[int64]bool
+	location  *time.Location
+	// stale is set while what is in memory did not come from storage,
+	// e.g. after starting from a snapshot.
+	stale        bool
+	snapshotPath string
+	mux          sync.RWMutex
+	stopChan     chan struct{}
+	wg           sync.WaitGroup
 }
 
 func (cm *ClientManager) GetAllClients() map[string]*ClientConfig {
@@ -22,58 +40,130 @@ func (cm *ClientManager) GetAllClients() map[string]*ClientConfig {
 
 	clients := make(map[string]*ClientConfig)
 	for id, config := range cm.clients {
-		clients[id] = &ClientConfig{
-			ClientID:    config.ClientID,
-			Capacity:    config.Capacity,
-			RatePerSec:  config.RatePerSec,
-			CreatedAt:   config.CreatedAt,
-			LastUpdated: config.LastUpdated,
-		}
+		client := *config
+		clients[id] = &client
 	}
 	return clients
 }
 
-func NewClientManager(storage ClientStorage) *ClientManager {
+func NewClientManager(storage ClientStorage, rateLimiter *RateLimiter) *ClientManager {
 	cm := &ClientManager{
-		storage: storage,
-		clients: make(map[string]*ClientConfig),
+		storage:     storage,
+		rateLimiter: rateLimiter,
+		clients:     make(map[string]*ClientConfig),
+		effective:   make(map[string]*ClientConfig),
+		patterns:    newClientPatterns(nil),
+		plans:       make(map[string]*Plan),
+		overrides:   make(map[int64]*Override),
+		active:      make(map[int64]bool),
+		location:    time.UTC,
+		stopChan:    make(chan struct{}),
+	}
+	if planStorage, ok := storage.(PlanStorage); ok {
+		cm.planStorage = planStorage
 	}
+	if overrideStorage, ok := storage.(OverrideStorage); ok {
+		cm.overrideStorage = overrideStorage
+	}
+	cm.loadInitialPlans()
+	cm.loadInitialOverrides()
 	cm.loadInitialClients()
 	return cm
 }
 
 func (cm *ClientManager) loadInitialClients() {
-	clients, err := cm.storage.GetAllClients()
+	clients, err := cm.storage.GetAllClients(context.Background())
 	if err != nil {
 		log.Printf("Failed to load initial clients: %v", err)
+		cm.stale = true
 		return
 	}
 
 	cm.mux.Lock()
 	defer cm.mux.Unlock()
 	cm.clients = clients
+	for id, client := range clients {
+		cm.effective[id] = cm.resolveLocked(client)
+	}
+	cm.patterns = newClientPatterns(clients)
 }
 
-func (cm *ClientManager) AddClient(clientID string, capacity, rate int) error {
-	client := &ClientConfig{
-		ClientID:    clientID,
-		Capacity:    capacity,
-		RatePerSec:  rate,
-		CreatedAt:   time.Now(),
-		LastUpdated: time.Now(),
+// resolveLocked returns the config the limiter should enforce for client:
+// any limit left at zero is taken from the client's plan, and an active
+// override takes precedence over both.
+func (cm *ClientManager) resolveLocked(client *ClientConfig) *ClientConfig {
+	override := cm.activeOverrideLocked(client.ClientID)
+	if override == nil {
+		return cm.applyPlanLocked(client)
 	}
 
-	if err := cm.storage.SaveClient(client); err != nil {
+	effective := *cm.applyPlanLocked(client)
+	if override.Capacity > 0 {
+		effective.Capacity = override.Capacity
+	}
+	if override.RatePerSec > 0 {
+		effective.RatePerSec = override.RatePerSec
+	}
+	return &effective
+}
+
+func (cm *ClientManager) applyPlanLocked(client *ClientConfig) *ClientConfig {
+	plan, exists := cm.plans[client.Plan]
+	if client.Plan == "" || !exists {
+		return client
+	}
+
+	effective := *client
+	if effective.Capacity == 0 {
+		effective.Capacity = plan.Capacity
+	}
+	if effective.RatePerSec == 0 {
+		effective.RatePerSec = plan.RatePerSec
+	}
+	if effective.Algorithm == "" {
+		effective.Algorithm = plan.Algorithm
+	}
+	if effective.DailyQuota == 0 {
+		effective.DailyQuota = plan.DailyQuota
+	}
+	if effective.MonthlyQuota == 0 {
+		effective.MonthlyQuota = plan.MonthlyQuota
+	}
+	return &effective
+}
+
+func (cm *ClientManager) AddClient(ctx context.Context, client *ClientConfig) error {
+	if err := cm.checkPlan(client.Plan); err != nil {
+		return err
+	}
+
+	// Postgres keeps microseconds; truncating lets the version read back
+	// from storage compare equal.
+	client.CreatedAt = time.Now().Truncate(time.Microsecond)
+	client.LastUpdated = client.CreatedAt
+
+	if err := cm.storage.SaveClient(ctx, client); err != nil {
 		return err
 	}
 
 	cm.mux.Lock()
-	defer cm.mux.Unlock()
-	cm.clients[clientID] = client
+	cm.clients[client.ClientID] = client
+	effective := cm.resolveLocked(client)
+	cm.effective[client.ClientID] = effective
+	cm.patterns = newClientPatterns(cm.clients)
+	cm.mux.Unlock()
+
+	// A request may have arrived before registration and been given a
+	// bucket with the default limits; drop it so the next one uses ours.
+	// Route buckets are reshaped to the client's own rules.
+	if cm.rateLimiter != nil {
+		cm.rateLimiter.RemoveBucket(client.ClientID)
+		cm.rateLimiter.UpdateBucket(client.ClientID, effective)
+	}
 	return nil
 }
 
-func (cm *ClientManager) RemoveClient(clientID string) error {
+func (cm *ClientManager) RemoveClient(ctx context.Context, clientID string) error {
 	cm.mux.RLock()
 	_, exists := cm.clients[clientID]
 	cm.mux.RUnlock()
@@ -82,22 +172,31 @@ func (cm *ClientManager) RemoveClient(clientID string) error {
 		return fmt.Errorf("client not found")
 	}
 
-	if err := cm.storage.DeleteClient(clientID); err != nil {
+	if err := cm.storage.DeleteClient(ctx, clientID); err != nil {
 		return fmt.Errorf("database error: %w", err)
 	}
 
-	cm.mux.Lock()
-	delete(cm.clients, clientID)
-	cm.mux.Unlock()
-
-	if cm.rateLimiter != nil {
-		cm.rateLimiter.RemoveBucket(clientID)
-	}
-
+	cm.dropClient(clientID)
 	return nil
 }
 
+// GetClientConfig returns the effective limits for a client, with its
+// plan applied. A client without its own entry gets the entry of the
+// most specific CIDR or pattern matching it.
 func (cm *ClientManager) GetClientConfig(clientID string) (*ClientConfig, bool) {
+	cm.mux.RLock()
+	defer cm.mux.RUnlock()
+	if config, exists := cm.effective[clientID]; exists {
+		return config, true
+	}
+	if id, ok := cm.patterns.match(clientID); ok {
+		return cm.effective[id], true
+	}
+	return nil, false
+}
+
+// GetClient returns the client as stored, without plan defaults.
+func (cm *ClientManager) GetClient(clientID string) (*ClientConfig, bool) {
 	cm.mux.RLock()
 	defer cm.mux.RUnlock()
 	config, exists := cm.clients[clientID]
@@ -113,7 +212,7 @@ func (cm *ClientManager) HandleClientAPI(w http.ResponseWriter, r *http.Request)
 			return
 		}
 
-		if err := cm.AddClient(config.ClientID, config.Capacity, config.RatePerSec); err != nil {
+		if err := cm.AddClient(r.Context(), &config); err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
@@ -128,7 +227,7 @@ func (cm *ClientManager) HandleClientAPI(w http.ResponseWriter, r *http.Request)
 			return
 		}
 
-		if err := cm.RemoveClient(clientID); err != nil {
+		if err := cm.RemoveClient(r.Context(), clientID); err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
@@ -140,45 +239,31 @@ func (cm *ClientManager) HandleClientAPI(w http.ResponseWriter, r *http.Request)
 	}
 }
 
-func (rl *RateLimiter) AllowWithConfig(clientID string, config *ClientConfig) bool {
-	rl.mux.RLock()
-	bucket, exists := rl.buckets[clientID]
-	rl.mux.RUnlock()
-
-	if !exists {
-		rl.mux.Lock()
-		bucket = &TokenBucket{
-			capacity:   config.Capacity,
-			tokens:     config.Capacity,
-			rate:       config.RatePerSec,
-			lastRefill: time.Now(),
-		}
-		rl.buckets[clientID] = bucket
-		rl.mux.Unlock()
-	}
-
-	bucket.mux.Lock()
-	defer bucket.mux.Unlock()
-
-	if bucket.tokens > 0 {
-		bucket.tokens--
-		return true
-	}
-	return false
+func (rl *RateLimiter) AllowWithConfig(clientID string, config *ClientConfig, cost int) Decision {
+	return rl.allow(clientID, config.Capacity, config.RatePerSec, rl.algorithmFor(config), cost)
 }
 
-func (cm *ClientManager) UpdateClient(client *ClientConfig) error {
+func (cm *ClientManager) UpdateClient(ctx context.Context, client *ClientConfig) error {
 	if client.ClientID == "" {
 		return fmt.Errorf("client_id cannot be empty")
 	}
+	if err := cm.checkPlan(client.Plan); err != nil {
+		return err
+	}
 
-	if err := cm.storage.SaveClient(client); err != nil {
+	client.LastUpdated = time.Now().Truncate(time.Microsecond)
+	if err := cm.storage.SaveClient(ctx, client); err != nil {
 		return fmt.Errorf("database error: %w", err)
 	}
 
 	cm.mux.Lock()
 	cm.clients[client.ClientID] = client
+	effective := cm.resolveLocked(client)
+	cm.effective[client.ClientID] = effective
 	cm.mux.Unlock()
 
+	if cm.rateLimiter != nil {
+		cm.rateLimiter.UpdateBucket(client.ClientID, effective)
+	}
 	return nil
 }
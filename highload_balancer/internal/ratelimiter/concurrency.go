@@ -0,0 +1,50 @@
+package ratelimiter
+
+import "sync"
+
+// ConcurrencyLimiter caps how many requests a client may have in flight
+// at once, independent of how fast it sends them.
+type ConcurrencyLimiter struct {
+	inFlight map[string]int
+	mux      sync.Mutex
+}
+
+func NewConcurrencyLimiter() *ConcurrencyLimiter {
+	return &ConcurrencyLimiter{
+		inFlight: make(map[string]int),
+	}
+}
+
+// Acquire takes a slot for clientID if fewer than limit are in use. The
+// returned release is safe to call more than once.
+func (cl *ConcurrencyLimiter) Acquire(clientID string, limit int) (func(), bool) {
+	cl.mux.Lock()
+	defer cl.mux.Unlock()
+
+	if cl.inFlight[clientID] >= limit {
+		return nil, false
+	}
+	cl.inFlight[clientID]++
+
+	var once sync.Once
+	return func() {
+		once.Do(func() { cl.release(clientID) })
+	}, true
+}
+
+func (cl *ConcurrencyLimiter) release(clientID string) {
+	cl.mux.Lock()
+	defer cl.mux.Unlock()
+
+	if cl.inFlight[clientID] <= 1 {
+		delete(cl.inFlight, clientID)
+		return
+	}
+	cl.inFlight[clientID]--
+}
+
+func (cl *ConcurrencyLimiter) InFlight(clientID string) int {
+	cl.mux.Lock()
+	defer cl.mux.Unlock()
+	return cl.inFlight[clientID]
+}
@@ -0,0 +1,45 @@
+package ratelimiter_test
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/se1y4/highload-balancer/internal/ratelimiter"
+	"github.com/se1y4/highload-balancer/internal/ratelimiter/storagetest"
+)
+
+func TestFileStorage(t *testing.T) {
+	storagetest.Run(t, func(t *testing.T, clients []*ratelimiter.ClientConfig) ratelimiter.ClientStorage {
+		raw, err := json.Marshal(map[string]any{"clients": clients})
+		if err != nil {
+			t.Fatal(err)
+		}
+		path := filepath.Join(t.TempDir(), "clients.json")
+		if err := os.WriteFile(path, raw, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		s, err := ratelimiter.NewFileStorage(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return s
+	})
+}
+
+func TestFileStorageRejectsUnknownAlgorithms(t *testing.T) {
+	for name, doc := range map[string]string{
+		"client": `{"clients": [{"client_id": "a", "capacity": 10, "algorithm": "token-bukket"}]}`,
+		"plan":   `{"plans": [{"name": "pro", "capacity": 10, "algorithm": "gcr"}]}`,
+		"route":  `{"clients": [{"client_id": "a", "capacity": 10, "routes": [{"name": "r", "capacity": 1, "algorithm": "x"}]}]}`,
+	} {
+		path := filepath.Join(t.TempDir(), "clients.json")
+		if err := os.WriteFile(path, []byte(doc), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := ratelimiter.NewFileStorage(path); err == nil {
+			t.Errorf("%s with an unknown algorithm accepted", name)
+		}
+	}
+}
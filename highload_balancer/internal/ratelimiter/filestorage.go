@@ -0,0 +1,245 @@
+package ratelimiter
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+var ErrReadOnlyStorage = errors.New("storage is read-only")
+
+// clientsFile is the layout of a file read by FileStorage. Fields use the
+// same names as the API in both YAML and JSON.
+type clientsFile struct {
+	Clients []*ClientConfig `json:"clients"`
+	Plans   []*Plan         `json:"plans"`
+	ACL     []*ACLEntry     `json:"acl"`
+}
+
+// FileStorage serves clients, plans and ACL entries from a YAML or JSON
+// file maintained by hand or by configuration management. Writes through
+// the API fail with ErrReadOnlyStorage. The file is read again whenever it
+// changes, so edits are picked up on the next resync.
+type FileStorage struct {
+	path    string
+	modTime time.Time
+	data    *clientsFile
+	mux     sync.Mutex
+}
+
+func NewFileStorage(path string) (*FileStorage, error) {
+	s := &FileStorage{path: path}
+	if _, err := s.load(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// load returns the file contents, parsing the file again if it has been
+// modified since the last call. If it no longer parses, the last good
+// contents are kept and the error is returned.
+func (s *FileStorage) load() (*clientsFile, error) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	info, err := os.Stat(s.path)
+	if err != nil {
+		return s.data, err
+	}
+	if s.data != nil && info.ModTime().Equal(s.modTime) {
+		return s.data, nil
+	}
+
+	raw, err := os.ReadFile(s.path)
+	if err != nil {
+		return s.data, err
+	}
+	data, err := parseClientsFile(raw, info.ModTime())
+	if err != nil {
+		return s.data, fmt.Errorf("invalid clients file %s: %w", s.path, err)
+	}
+	s.data, s.modTime = data, info.ModTime()
+	return data, nil
+}
+
+// parseClientsFile decodes YAML, of which JSON is a subset. Clients and
+// plans without timestamps are stamped with modTime, so that changed
+// limits are applied after an edit.
+func parseClientsFile(raw []byte, modTime time.Time) (*clientsFile, error) {
+	var doc any
+	if err := yaml.Unmarshal(raw, &doc); err != nil {
+		return nil, err
+	}
+	// Round-trip through JSON so the json tags of the API types apply.
+	b, err := json.Marshal(jsonCompatible(doc))
+	if err != nil {
+		return nil, err
+	}
+	var data clientsFile
+	if err := json.Unmarshal(b, &data); err != nil {
+		return nil, err
+	}
+
+	seen := make(map[string]bool)
+	for _, client := range data.Clients {
+		if client.ClientID == "" {
+			return nil, errors.New("client without client_id")
+		}
+		if seen[client.ClientID] {
+			return nil, fmt.Errorf("duplicate client %s", client.ClientID)
+		}
+		seen[client.ClientID] = true
+		if err := ValidateClientID(client.ClientID); err != nil {
+			return nil, err
+		}
+		if err := client.ValidateLimits(); err != nil {
+			return nil, fmt.Errorf("client %s: %w", client.ClientID, err)
+		}
+		if err := ValidateRouteRules(client.Routes); err != nil {
+			return nil, fmt.Errorf("client %s: %w", client.ClientID, err)
+		}
+		if client.CreatedAt.IsZero() {
+			client.CreatedAt = modTime
+		}
+		if client.LastUpdated.IsZero() {
+			client.LastUpdated = modTime
+		}
+	}
+	for _, plan := range data.Plans {
+		if plan.Name == "" {
+			return nil, errors.New("plan without name")
+		}
+		if plan.Capacity <= 0 || plan.RatePerSec < 0 {
+			return nil, fmt.Errorf("plan %s: capacity must be positive and rate_per_sec not negative", plan.Name)
+		}
+		if !plan.Algorithm.Valid() {
+			return nil, fmt.Errorf("plan %s: unknown algorithm %q", plan.Name, plan.Algorithm)
+		}
+		if plan.CreatedAt.IsZero() {
+			plan.CreatedAt = modTime
+		}
+		if plan.LastUpdated.IsZero() {
+			plan.LastUpdated = modTime
+		}
+	}
+	for _, entry := range data.ACL {
+		prefix, err := parsePrefix(entry.CIDR)
+		if err != nil || (entry.Action != ACLAllow && entry.Action != ACLDeny) {
+			return nil, fmt.Errorf("%w: %s", ErrInvalidACLEntry, entry.CIDR)
+		}
+		entry.CIDR = prefix.String()
+	}
+	return &data, nil
+}
+
+// jsonCompatible converts the map[interface{}]interface{} values produced
+// by yaml.v2 into map[string]interface{}.
+func jsonCompatible(v any) any {
+	switch v := v.(type) {
+	case map[any]any:
+		m := make(map[string]any, len(v))
+		for key, value := range v {
+			m[fmt.Sprint(key)] = jsonCompatible(value)
+		}
+		return m
+	case []any:
+		for i, value := range v {
+			v[i] = jsonCompatible(value)
+		}
+	}
+	return v
+}
+
+func (s *FileStorage) ReadOnly() bool {
+	return true
+}
+
+func (s *FileStorage) SaveClient(context.Context, *ClientConfig) error {
+	return ErrReadOnlyStorage
+}
+
+func (s *FileStorage) DeleteClient(context.Context, string) error {
+	return ErrReadOnlyStorage
+}
+
+func (s *FileStorage) GetClient(ctx context.Context, clientID string) (*ClientConfig, error) {
+	data, err := s.load()
+	if err != nil {
+		return nil, err
+	}
+	for _, client := range data.Clients {
+		if client.ClientID == clientID {
+			c := *client
+			return &c, nil
+		}
+	}
+	return nil, nil
+}
+
+func (s *FileStorage) GetAllClients(ctx context.Context) (map[string]*ClientConfig, error) {
+	data, err := s.load()
+	if err != nil {
+		return nil, err
+	}
+	clients := make(map[string]*ClientConfig, len(data.Clients))
+	for _, client := range data.Clients {
+		c := *client
+		clients[c.ClientID] = &c
+	}
+	return clients, nil
+}
+
+func (s *FileStorage) SavePlan(context.Context, *Plan) error {
+	return ErrReadOnlyStorage
+}
+
+func (s *FileStorage) DeletePlan(context.Context, string) error {
+	return ErrReadOnlyStorage
+}
+
+func (s *FileStorage) GetAllPlans(ctx context.Context) (map[string]*Plan, error) {
+	data, err := s.load()
+	if err != nil {
+		return nil, err
+	}
+	plans := make(map[string]*Plan, len(data.Plans))
+	for _, plan := range data.Plans {
+		p := *plan
+		plans[p.Name] = &p
+	}
+	return plans, nil
+}
+
+func (s *FileStorage) SaveACLEntry(context.Context, *ACLEntry) error {
+	return ErrReadOnlyStorage
+}
+
+func (s *FileStorage) DeleteACLEntry(context.Context, string) error {
+	return ErrReadOnlyStorage
+}
+
+func (s *FileStorage) GetACLEntries(ctx context.Context) ([]*ACLEntry, error) {
+	data, err := s.load()
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	var entries []*ACLEntry
+	for _, entry := range data.ACL {
+		if !entry.expired(now) {
+			e := *entry
+			entries = append(entries, &e)
+		}
+	}
+	return entries, nil
+}
+
+func (s *FileStorage) Close() error {
+	return nil
+}
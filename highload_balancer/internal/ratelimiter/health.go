@@ -0,0 +1,160 @@
+package ratelimiter
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"log"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+var ErrStorageUnavailable = errors.New("storage is unavailable")
+
+// StorageStatus reports whether storage is reachable and since when.
+type StorageStatus struct {
+	Healthy   bool      `json:"healthy"`
+	Since     time.Time `json:"since"`
+	LastError string    `json:"last_error,omitempty"`
+}
+
+// IsStorageTimeout reports whether a storage call failed because it ran
+// out of time, either its own query timeout or the caller's deadline.
+func IsStorageTimeout(err error) bool {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		// query_canceled: PostgreSQL's answer to a cancelled context or
+		// to statement_timeout.
+		return pqErr.Code == "57014"
+	}
+	var netErr net.Error
+	return errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &netErr) && netErr.Timeout())
+}
+
+// IsStorageUnavailable reports whether a storage call failed because
+// storage could not be reached.
+func IsStorageUnavailable(err error) bool {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		// Connection exceptions, and the server shutting down.
+		return pqErr.Code.Class() == "08" || (pqErr.Code.Class() == "57" && pqErr.Code != "57014")
+	}
+	var netErr net.Error
+	return errors.Is(err, ErrStorageUnavailable) ||
+		errors.Is(err, driver.ErrBadConn) ||
+		errors.Is(err, sql.ErrConnDone) ||
+		(errors.As(err, &netErr) && !netErr.Timeout())
+}
+
+// HealthReporter is implemented by storages that can be unreachable.
+type HealthReporter interface {
+	Status() StorageStatus
+}
+
+type storageHealth struct {
+	status StorageStatus
+	mux    sync.RWMutex
+}
+
+func (h *storageHealth) set(err error) {
+	h.mux.Lock()
+	defer h.mux.Unlock()
+
+	healthy := err == nil
+	if healthy != h.status.Healthy || h.status.Since.IsZero() {
+		h.status.Healthy = healthy
+		h.status.Since = time.Now()
+	}
+	h.status.LastError = ""
+	if err != nil {
+		h.status.LastError = err.Error()
+	}
+}
+
+func (h *storageHealth) get() StorageStatus {
+	h.mux.RLock()
+	defer h.mux.RUnlock()
+	return h.status
+}
+
+func (s *PostgresStorage) ping() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	return s.db.PingContext(ctx)
+}
+
+func (s *PostgresStorage) Status() StorageStatus {
+	return s.health.get()
+}
+
+func (s *PostgresStorage) Healthy() bool {
+	return s.health.get().Healthy
+}
+
+// Monitor pings the server every interval while it is up and with
+// exponential backoff, up to maxBackoff, while it is down. onRecover runs
+// after every outage, including one at startup; if it fails, the storage
+// stays down and it is retried.
+func (s *PostgresStorage) Monitor(interval, maxBackoff time.Duration, onRecover func() error, stop <-chan struct{}) {
+	if interval <= 0 {
+		interval = 5 * time.Second
+	}
+	if maxBackoff < time.Second {
+		maxBackoff = 30 * time.Second
+	}
+
+	backoff := time.Second
+	pending := !s.Healthy()
+	for {
+		wait := interval
+		if pending {
+			wait = backoff
+		}
+		select {
+		case <-time.After(wait):
+		case <-stop:
+			return
+		}
+
+		err := s.ping()
+		if err == nil && pending {
+			err = onRecover()
+		}
+		wasHealthy := s.Healthy()
+		s.health.set(err)
+
+		switch {
+		case err != nil:
+			if wasHealthy {
+				log.Printf("PostgreSQL became unavailable: %v", err)
+				backoff = time.Second
+			} else {
+				backoff = min(backoff*2, maxBackoff)
+			}
+			pending = true
+		case pending:
+			log.Printf("PostgreSQL is available again")
+			pending = false
+			backoff = time.Second
+		}
+	}
+}
+
+// StorageStatus reports the health of the client storage; storages that
+// cannot become unreachable are always healthy.
+func (cm *ClientManager) StorageStatus() StorageStatus {
+	if reporter, ok := cm.storage.(HealthReporter); ok {
+		return reporter.Status()
+	}
+	return StorageStatus{Healthy: true}
+}
+
+// ReadOnly reports whether the client storage rejects all writes.
+func (cm *ClientManager) ReadOnly() bool {
+	storage, ok := cm.storage.(interface{ ReadOnly() bool })
+	return ok && storage.ReadOnly()
+}
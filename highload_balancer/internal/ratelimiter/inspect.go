@@ -0,0 +1,89 @@
+package ratelimiter
+
+import (
+	"errors"
+	"math"
+	"time"
+)
+
+var ErrSharedBuckets = errors.New("bucket state is held by the shared store")
+
+// BucketInfo describes one of a client's buckets. Capacity and rate are
+// what this instance enforces, after division across cluster peers.
+// A bucket that is not Live has not been used recently and is full.
+type BucketInfo struct {
+	Key         string        `json:"key"`
+	Route       string        `json:"route,omitempty"`
+	Algorithm   AlgorithmType `json:"algorithm"`
+	Live        bool          `json:"live"`
+	Tokens      float64       `json:"tokens"`
+	Capacity    float64       `json:"capacity"`
+	Rate        float64       `json:"rate"`
+	LastRefill  *time.Time    `json:"last_refill,omitempty"`
+	NextTokenAt *time.Time    `json:"next_token_at,omitempty"`
+}
+
+// InspectBuckets returns the client's bucket and its route buckets, as
+// AllowWithConfig and AllowRoute would see them. config may be nil for
+// clients on the default limits.
+func (rl *RateLimiter) InspectBuckets(clientID string, config *ClientConfig) ([]BucketInfo, error) {
+	if rl.config.Shared != nil && time.Now().UnixNano() >= rl.fallbackUntil.Load() {
+		return nil, ErrSharedBuckets
+	}
+
+	algorithm := rl.algorithmFor(&ClientConfig{})
+	capacity, rate := rl.defaultCap, rl.defaultRate
+	if config != nil {
+		algorithm = rl.algorithmFor(config)
+		capacity, rate = config.Capacity, config.RatePerSec
+	}
+
+	now := time.Now()
+	buckets := []BucketInfo{rl.inspect(clientID, capacity, rate, algorithm, now)}
+	for _, rule := range rl.routesFor(config) {
+		ruleAlgorithm := rule.Algorithm
+		if ruleAlgorithm == "" {
+			ruleAlgorithm = rl.algorithmFor(&ClientConfig{})
+		}
+		info := rl.inspect(routeBucketKey(clientID, rule.Name), rule.Capacity, rule.RatePerSec, ruleAlgorithm, now)
+		info.Route = rule.Name
+		buckets = append(buckets, info)
+	}
+	return buckets, nil
+}
+
+func (rl *RateLimiter) inspect(key string, capacity, rate int, algorithm AlgorithmType, now time.Time) BucketInfo {
+	c, r := rl.scaled(capacity, rate)
+	info := BucketInfo{
+		Key:       key,
+		Algorithm: algorithm,
+		Tokens:    c,
+		Capacity:  c,
+		Rate:      r,
+	}
+
+	rl.shard(key).update(key, func(entry *bucketEntry) {
+		state := entry.limiter.Inspect(now)
+		info.Live = true
+		info.Algorithm = entry.limiter.Algorithm()
+		info.Tokens = state.Tokens
+		if !state.LastRefill.IsZero() {
+			info.LastRefill = &state.LastRefill
+		}
+		// The next token is the one that takes the balance to the next
+		// whole unit.
+		if delay := entry.limiter.Delay(now, int(math.Floor(state.Tokens))+1); delay != never {
+			next := now.Add(delay)
+			info.NextTokenAt = &next
+		}
+	})
+	return info
+}
+
+// ResetBuckets refills the client's bucket and its route buckets.
+func (rl *RateLimiter) ResetBuckets(clientID string, config *ClientConfig) {
+	rl.RemoveBucket(clientID)
+	for _, rule := range rl.routesFor(config) {
+		rl.RemoveBucket(routeBucketKey(clientID, rule.Name))
+	}
+}
@@ -1,115 +1,316 @@
 package ratelimiter
 
 import (
+	"context"
+	"log"
 	"sync"
+	"sync/atomic"
 	"time"
+
+	"github.com/se1y4/highload-balancer/internal/metrics"
 )
 
 type TokenBucket struct {
-	capacity   int
-	tokens     int
-	rate       int
+	capacity   float64
+	tokens     float64
+	rate       float64
 	lastRefill time.Time
 	mux        sync.Mutex
 }
 
 type RateLimiter struct {
-	buckets     map[string]*TokenBucket
-	defaultCap  int
-	defaultRate int
-	mux         sync.RWMutex
-	stopChan    chan struct{}
+	shards        []*bucketShard
+	defaultCap    int
+	defaultRate   int
+	config        Config
+	fallbackUntil atomic.Int64
+	peers         atomic.Int32
+	queues        *waitQueues
 }
 
-func NewRateLimiter(defaultCap, defaultRate int, refillInterval time.Duration) *RateLimiter {
+func NewRateLimiter(defaultCap, defaultRate int, config *Config) *RateLimiter {
 	rl := &RateLimiter{
-		buckets:     make(map[string]*TokenBucket),
 		defaultCap:  defaultCap,
 		defaultRate: defaultRate,
-		stopChan:    make(chan struct{}),
+		queues:      newWaitQueues(),
+	}
+	rl.peers.Store(1)
+	if config != nil {
+		rl.config = *config
 	}
 
-	go rl.autoRefill(refillInterval)
+	shards := max(rl.config.Shards, 1)
+	perShard := 0
+	if rl.config.MaxBuckets > 0 {
+		perShard = max(rl.config.MaxBuckets/shards, 1)
+	}
+	for i := 0; i < shards; i++ {
+		rl.shards = append(rl.shards, newBucketShard(perShard, rl.config.IdleTTL))
+	}
+	if rl.config.SharedTimeout <= 0 {
+		rl.config.SharedTimeout = 50 * time.Millisecond
+	}
+	if rl.config.FallbackRetry <= 0 {
+		rl.config.FallbackRetry = time.Second
+	}
 	return rl
 }
 
-func (rl *RateLimiter) Stop() {
-	close(rl.stopChan)
+func newTokenBucket(capacity, rate float64, now time.Time) *TokenBucket {
+	return &TokenBucket{
+		capacity:   capacity,
+		tokens:     capacity,
+		rate:       rate,
+		lastRefill: now,
+	}
 }
 
-func (rl *RateLimiter) autoRefill(interval time.Duration) {
-	ticker := time.NewTicker(interval)
-	defer ticker.Stop()
+// refill credits the tokens accrued since the last call. Fractional tokens
+// are kept, so low rates still accumulate between frequent requests.
+func (tb *TokenBucket) refill(now time.Time) {
+	elapsed := now.Sub(tb.lastRefill).Seconds()
+	if elapsed <= 0 {
+		return
+	}
+	tb.tokens = min(tb.capacity, tb.tokens+elapsed*tb.rate)
+	tb.lastRefill = now
+}
 
-	for {
-		select {
-		case <-ticker.C:
-			rl.refillAllBuckets()
-		case <-rl.stopChan:
-			return
-		}
+func (tb *TokenBucket) Algorithm() AlgorithmType {
+	return TokenBucketAlgorithm
+}
+
+// Reconfigure settles tokens earned at the old rate, then applies the new
+// limits, clamping the balance to the new capacity.
+func (tb *TokenBucket) Reconfigure(capacity, rate float64, now time.Time) {
+	tb.mux.Lock()
+	defer tb.mux.Unlock()
+
+	tb.refill(now)
+	tb.capacity = capacity
+	tb.rate = rate
+	tb.tokens = min(tb.tokens, capacity)
+}
+
+func (tb *TokenBucket) Full(now time.Time) bool {
+	tb.mux.Lock()
+	defer tb.mux.Unlock()
+
+	tb.refill(now)
+	return tb.tokens >= tb.capacity
+}
+
+func (tb *TokenBucket) Inspect(now time.Time) LimiterState {
+	tb.mux.Lock()
+	defer tb.mux.Unlock()
+
+	// Computed without refilling, so LastRefill stays the time of the
+	// last request rather than of this call.
+	elapsed := max(now.Sub(tb.lastRefill).Seconds(), 0)
+	return LimiterState{
+		Tokens:     min(tb.capacity, tb.tokens+elapsed*tb.rate),
+		LastRefill: tb.lastRefill,
 	}
 }
 
-func (rl *RateLimiter) refillAllBuckets() {
-	rl.mux.Lock()
-	defer rl.mux.Unlock()
+func (tb *TokenBucket) Delay(now time.Time, n int) time.Duration {
+	tb.mux.Lock()
+	defer tb.mux.Unlock()
 
-	for _, bucket := range rl.buckets {
-		bucket.refill()
+	tb.refill(now)
+	missing := float64(n) - tb.tokens
+	if missing <= 0 {
+		return 0
+	}
+	if tb.rate <= 0 || float64(n) > tb.capacity {
+		return never
 	}
+	return time.Duration(missing / tb.rate * float64(time.Second))
 }
 
-func (tb *TokenBucket) refill() {
+func (tb *TokenBucket) Refund(now time.Time, n int) {
 	tb.mux.Lock()
 	defer tb.mux.Unlock()
 
+	tb.refill(now)
+	tb.tokens = min(tb.capacity, tb.tokens+float64(n))
+}
+
+func (tb *TokenBucket) AllowN(now time.Time, n int) (bool, float64) {
+	tb.mux.Lock()
+	defer tb.mux.Unlock()
+
+	tb.refill(now)
+	if tb.tokens >= float64(n) {
+		tb.tokens -= float64(n)
+		return true, tb.tokens
+	}
+	return false, max(tb.tokens, 0)
+}
+
+func (rl *RateLimiter) shard(key string) *bucketShard {
+	return rl.shards[shardIndex(key, len(rl.shards))]
+}
+
+func (rl *RateLimiter) getBucket(key string, capacity, rate int, algorithm AlgorithmType) Limiter {
 	now := time.Now()
-	elapsed := now.Sub(tb.lastRefill).Seconds()
-	tokensToAdd := int(elapsed * float64(tb.rate))
+	return rl.shard(key).get(key, now, func() *bucketEntry {
+		c, r := rl.scaled(capacity, rate)
+		return &bucketEntry{
+			limiter:  NewLimiter(algorithm, c, r, now),
+			capacity: capacity,
+			rate:     rate,
+		}
+	}, func(entry *bucketEntry) {
+		// Several addresses matched by one pattern client each have a
+		// bucket, which UpdateBucket cannot find by the client's ID;
+		// they pick up changed limits here instead.
+		changed := algorithm.Valid() && entry.limiter.Algorithm() != algorithm
+		if changed || entry.capacity != capacity || entry.rate != rate {
+			rl.reshape(entry, capacity, rate, algorithm, now)
+		}
+	})
+}
 
-	if tokensToAdd > 0 {
-		tb.tokens = min(tb.capacity, tb.tokens+tokensToAdd)
-		tb.lastRefill = now
+// scaled divides configured limits by the number of live cluster peers so
+// that together the replicas admit what a single instance would.
+func (rl *RateLimiter) scaled(capacity, rate int) (float64, float64) {
+	n := float64(rl.peers.Load())
+	c := float64(capacity) / n
+	if capacity > 0 {
+		c = max(c, 1)
 	}
+	return c, float64(rate) / n
 }
 
-func (rl *RateLimiter) Allow(clientID string) bool {
-	rl.mux.RLock()
-	bucket, exists := rl.buckets[clientID]
-	rl.mux.RUnlock()
+// SetPeers sets how many balancer instances, including this one, share
+// the traffic, and rescales every live bucket accordingly.
+func (rl *RateLimiter) SetPeers(n int) {
+	n = max(n, 1)
+	if int(rl.peers.Swap(int32(n))) == n {
+		return
+	}
+
+	now := time.Now()
+	for _, shard := range rl.shards {
+		shard.forEach(func(entry *bucketEntry) {
+			c, r := rl.scaled(entry.capacity, entry.rate)
+			entry.limiter.Reconfigure(c, r, now)
+		})
+	}
+}
 
-	if !exists {
-		rl.mux.Lock()
-		bucket = &TokenBucket{
-			capacity:   rl.defaultCap,
-			tokens:     rl.defaultCap,
-			rate:       rl.defaultRate,
-			lastRefill: time.Now(),
+func (rl *RateLimiter) allow(key string, capacity, rate int, algorithm AlgorithmType, cost int) Decision {
+	decision := Decision{
+		Limit:     capacity,
+		Cost:      cost,
+		key:       key,
+		capacity:  capacity,
+		rate:      rate,
+		algorithm: algorithm,
+	}
+
+	if rl.config.Shared != nil && time.Now().UnixNano() >= rl.fallbackUntil.Load() {
+		ctx, cancel := context.WithTimeout(context.Background(), rl.config.SharedTimeout)
+		allowed, remaining, err := rl.config.Shared.Allow(ctx, key, algorithm, capacity, rate, cost)
+		cancel()
+		if err == nil {
+			decision.Allowed, decision.Remaining = allowed, int(remaining)
+			decision.shared = true
+			return decision
 		}
-		rl.buckets[clientID] = bucket
-		rl.mux.Unlock()
+		rl.enterFallback(err)
 	}
 
-	bucket.mux.Lock()
-	defer bucket.mux.Unlock()
+	allowed, remaining := rl.getBucket(key, capacity, rate, algorithm).AllowN(time.Now(), cost)
+	decision.Allowed, decision.Remaining = allowed, int(remaining)
+	return decision
+}
 
-	if bucket.tokens > 0 {
-		bucket.tokens--
-		return true
+// Refund returns the tokens taken by an admitted decision to the bucket
+// they came from, for a request that a later check turned away. It does
+// nothing for rejected decisions.
+func (rl *RateLimiter) Refund(decision Decision) {
+	if !decision.Allowed {
+		return
 	}
-	return false
+
+	if decision.shared {
+		ctx, cancel := context.WithTimeout(context.Background(), rl.config.SharedTimeout)
+		defer cancel()
+		err := rl.config.Shared.Refund(ctx, decision.key, decision.algorithm, decision.capacity, decision.rate, decision.Cost)
+		if err != nil {
+			log.Printf("Failed to refund shared bucket for %s: %v", decision.key, err)
+		}
+		return
+	}
+	rl.getBucket(decision.key, decision.capacity, decision.rate, decision.algorithm).Refund(time.Now(), decision.Cost)
 }
 
-func (rl *RateLimiter) RemoveBucket(clientID string) {
-	rl.mux.Lock()
-	defer rl.mux.Unlock()
-	delete(rl.buckets, clientID)
+func (rl *RateLimiter) enterFallback(err error) {
+	until := time.Now().Add(rl.config.FallbackRetry).UnixNano()
+	prev := rl.fallbackUntil.Load()
+	if prev < time.Now().UnixNano() && rl.fallbackUntil.CompareAndSwap(prev, until) {
+		log.Printf("Shared rate limit store unavailable, enforcing locally for %v: %v", rl.config.FallbackRetry, err)
+		metrics.SharedStoreFallbacks.Add(1)
+	}
 }
 
-func min(a, b int) int {
-	if a < b {
-		return a
+func (rl *RateLimiter) Allow(clientID string, cost int) Decision {
+	return rl.allow(clientID, rl.defaultCap, rl.defaultRate, rl.algorithmFor(&ClientConfig{}), cost)
+}
+
+// UpdateBucket applies new limits to the live buckets of a client,
+// including its route buckets.
+func (rl *RateLimiter) UpdateBucket(clientID string, config *ClientConfig) {
+	rl.updateBucket(clientID, config.Capacity, config.RatePerSec, rl.algorithmFor(config))
+
+	for _, rule := range rl.routesFor(config) {
+		algorithm := rule.Algorithm
+		if algorithm == "" {
+			algorithm = rl.algorithmFor(&ClientConfig{})
+		}
+		rl.updateBucket(routeBucketKey(clientID, rule.Name), rule.Capacity, rule.RatePerSec, algorithm)
+	}
+}
+
+// updateBucket reshapes a live bucket. A bucket whose algorithm changed
+// is replaced since its state cannot be converted.
+func (rl *RateLimiter) updateBucket(key string, capacity, rate int, algorithm AlgorithmType) {
+	rl.shard(key).update(key, func(entry *bucketEntry) {
+		rl.reshape(entry, capacity, rate, algorithm, time.Now())
+	})
+}
+
+func (rl *RateLimiter) reshape(entry *bucketEntry, capacity, rate int, algorithm AlgorithmType, now time.Time) {
+	entry.capacity = capacity
+	entry.rate = rate
+	c, r := rl.scaled(capacity, rate)
+	if entry.limiter.Algorithm() != algorithm {
+		entry.limiter = NewLimiter(algorithm, c, r, now)
+		return
+	}
+	entry.limiter.Reconfigure(c, r, now)
+}
+
+func (rl *RateLimiter) algorithmFor(config *ClientConfig) AlgorithmType {
+	if config.Algorithm == "" {
+		if rl.config.Algorithm == "" {
+			return TokenBucketAlgorithm
+		}
+		return rl.config.Algorithm
+	}
+	return config.Algorithm
+}
+
+func (rl *RateLimiter) RemoveBucket(clientID string) {
+	rl.shard(clientID).delete(clientID)
+
+	if rl.config.Shared != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), rl.config.SharedTimeout)
+		defer cancel()
+		if err := rl.config.Shared.Reset(ctx, clientID); err != nil {
+			log.Printf("Failed to reset shared bucket for %s: %v", clientID, err)
+		}
 	}
-	return b
 }
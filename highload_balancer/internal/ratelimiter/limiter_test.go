@@ -0,0 +1,100 @@
+package ratelimiter
+
+import (
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+const benchClients = 100_000
+
+func benchKeys() []string {
+	keys := make([]string, benchClients)
+	for i := range keys {
+		keys[i] = "client-" + strconv.Itoa(i)
+	}
+	return keys
+}
+
+func benchLimiter(shards, maxBuckets int) *RateLimiter {
+	return NewRateLimiter(100, 10, &Config{
+		Shards:     shards,
+		MaxBuckets: maxBuckets,
+		IdleTTL:    time.Minute,
+	})
+}
+
+func benchmarkAllow(b *testing.B, rl *RateLimiter) {
+	keys := benchKeys()
+	for _, key := range keys {
+		rl.Allow(key, 1)
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		rl.Allow(keys[i%len(keys)], 1)
+	}
+}
+
+func benchmarkAllowParallel(b *testing.B, rl *RateLimiter) {
+	keys := benchKeys()
+	for _, key := range keys {
+		rl.Allow(key, 1)
+	}
+
+	var next atomic.Int64
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		// Each goroutine walks the keys from its own offset so that
+		// they do not all contend for the same bucket.
+		i := int(next.Add(benchClients / 16))
+		for pb.Next() {
+			rl.Allow(keys[i%len(keys)], 1)
+			i++
+		}
+	})
+}
+
+func BenchmarkAllow100kClients(b *testing.B) {
+	benchmarkAllow(b, benchLimiter(64, 0))
+}
+
+func BenchmarkAllow100kClientsSingleShard(b *testing.B) {
+	benchmarkAllow(b, benchLimiter(1, 0))
+}
+
+func BenchmarkAllowParallel100kClients(b *testing.B) {
+	benchmarkAllowParallel(b, benchLimiter(64, 0))
+}
+
+func BenchmarkAllowParallel100kClientsSingleShard(b *testing.B) {
+	benchmarkAllowParallel(b, benchLimiter(1, 0))
+}
+
+// The bucket limit is a tenth of the active clients, so nearly every
+// request evicts the least recently used bucket.
+func BenchmarkAllow100kClientsEvicting(b *testing.B) {
+	benchmarkAllow(b, benchLimiter(64, benchClients/10))
+}
+
+func BenchmarkAllowParallel100kClientsEvicting(b *testing.B) {
+	benchmarkAllowParallel(b, benchLimiter(64, benchClients/10))
+}
+
+func TestBucketLimit(t *testing.T) {
+	rl := benchLimiter(8, 800)
+	for _, key := range benchKeys()[:10_000] {
+		rl.Allow(key, 1)
+	}
+
+	total := 0
+	for _, shard := range rl.shards {
+		total += shard.lru.Len()
+	}
+	if total > 800 {
+		t.Fatalf("%d buckets live, want at most 800", total)
+	}
+}
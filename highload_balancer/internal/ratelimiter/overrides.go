@@ -0,0 +1,355 @@
+package ratelimiter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"time"
+)
+
+var (
+	ErrOverrideNotFound  = errors.New("override not found")
+	ErrOverridesDisabled = errors.New("storage does not support overrides")
+)
+
+// Override temporarily replaces a client's capacity and rate; fields left
+// at zero keep the client's own value. It applies between StartsAt and
+// EndsAt, either bound optional. With a Schedule it applies only for
+// DurationSeconds after each cron occurrence within those bounds.
+type Override struct {
+	ID              int64      `json:"id"`
+	ClientID        string     `json:"client_id"`
+	Capacity        int        `json:"capacity,omitempty"`
+	RatePerSec      int        `json:"rate_per_sec,omitempty"`
+	StartsAt        *time.Time `json:"starts_at,omitempty"`
+	EndsAt          *time.Time `json:"ends_at,omitempty"`
+	Schedule        string     `json:"schedule,omitempty"`
+	DurationSeconds int        `json:"duration_seconds,omitempty"`
+	Comment         string     `json:"comment,omitempty"`
+	CreatedAt       time.Time  `json:"created_at"`
+
+	cron *cronSchedule
+}
+
+// OverrideStatus is an override as reported by the API.
+type OverrideStatus struct {
+	*Override
+	Active    bool       `json:"active"`
+	NextStart *time.Time `json:"next_start,omitempty"`
+}
+
+type OverrideStorage interface {
+	SaveOverride(context.Context, *Override) error
+	DeleteOverride(ctx context.Context, id int64) error
+	GetAllOverrides(context.Context) ([]*Override, error)
+}
+
+// Validate checks the override and parses its schedule.
+func (o *Override) Validate() error {
+	if o.ClientID == "" {
+		return errors.New("client_id is required")
+	}
+	if o.Capacity < 0 || o.RatePerSec < 0 {
+		return errors.New("capacity and rate_per_sec must not be negative")
+	}
+	if o.Capacity == 0 && o.RatePerSec == 0 {
+		return errors.New("capacity or rate_per_sec is required")
+	}
+	if o.StartsAt != nil && o.EndsAt != nil && !o.StartsAt.Before(*o.EndsAt) {
+		return errors.New("starts_at must be before ends_at")
+	}
+
+	if o.Schedule == "" {
+		if o.EndsAt == nil {
+			return errors.New("ends_at or schedule is required")
+		}
+		return nil
+	}
+	if o.DurationSeconds <= 0 {
+		return errors.New("duration_seconds is required with a schedule")
+	}
+	cron, err := parseCron(o.Schedule)
+	if err != nil {
+		return err
+	}
+	o.cron = cron
+	return nil
+}
+
+func (o *Override) duration() time.Duration {
+	return time.Duration(o.DurationSeconds) * time.Second
+}
+
+func (o *Override) expired(now time.Time) bool {
+	return o.EndsAt != nil && !now.Before(*o.EndsAt)
+}
+
+func (o *Override) activeAt(now time.Time) bool {
+	if o.StartsAt != nil && now.Before(*o.StartsAt) {
+		return false
+	}
+	if o.expired(now) {
+		return false
+	}
+	if o.cron == nil {
+		return true
+	}
+	// Active if an occurrence started within the last duration.
+	start := o.cron.next(now.Add(-o.duration()))
+	return !start.IsZero() && !start.After(now)
+}
+
+// nextStart returns when the override next becomes active after now.
+func (o *Override) nextStart(now time.Time) (time.Time, bool) {
+	from := now
+	if o.StartsAt != nil && o.StartsAt.After(now) {
+		if o.cron == nil {
+			return *o.StartsAt, true
+		}
+		from = o.StartsAt.Add(-time.Minute)
+	}
+	if o.cron == nil {
+		return time.Time{}, false
+	}
+
+	start := o.cron.next(from)
+	if start.IsZero() || (o.EndsAt != nil && !start.Before(*o.EndsAt)) {
+		return time.Time{}, false
+	}
+	return start, true
+}
+
+func (cm *ClientManager) loadInitialOverrides() {
+	if cm.overrideStorage == nil {
+		return
+	}
+
+	overrides, err := cm.overrideStorage.GetAllOverrides(context.Background())
+	if err != nil {
+		log.Printf("Failed to load limit overrides: %v", err)
+		cm.stale = true
+		return
+	}
+
+	cm.mux.Lock()
+	defer cm.mux.Unlock()
+	for _, o := range overrides {
+		if err := o.Validate(); err != nil {
+			log.Printf("Skipping invalid override %d: %v", o.ID, err)
+			continue
+		}
+		cm.overrides[o.ID] = o
+	}
+}
+
+// StartOverrides evaluates schedules in location every interval and
+// applies overrides to live buckets as they start and end. It must be
+// called at most once, before the manager is shared.
+func (cm *ClientManager) StartOverrides(location *time.Location, interval time.Duration) {
+	if location != nil {
+		cm.location = location
+	}
+	if interval <= 0 {
+		interval = time.Second
+	}
+	cm.applyOverrides()
+
+	cm.wg.Add(1)
+	go func() {
+		defer cm.wg.Done()
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				cm.applyOverrides()
+			case <-cm.stopChan:
+				return
+			}
+		}
+	}()
+}
+
+// Stop ends the background work started by StartOverrides and
+// StartSync.
+func (cm *ClientManager) Stop() {
+	close(cm.stopChan)
+	cm.wg.Wait()
+}
+
+// activeOverrideLocked returns the override in force for clientID; when
+// several are, the newest wins.
+func (cm *ClientManager) activeOverrideLocked(clientID string) *Override {
+	var active *Override
+	for id := range cm.active {
+		o := cm.overrides[id]
+		if o == nil || o.ClientID != clientID {
+			continue
+		}
+		if active == nil || o.CreatedAt.After(active.CreatedAt) || (o.CreatedAt.Equal(active.CreatedAt) && o.ID > active.ID) {
+			active = o
+		}
+	}
+	return active
+}
+
+// expiredOverrideTimeout bounds deleting expired overrides, so a slow
+// storage cannot hold up the schedule.
+const expiredOverrideTimeout = 5 * time.Second
+
+// applyOverrides re-evaluates which overrides are in force, drops expired
+// ones and reshapes the buckets of every client whose override changed.
+func (cm *ClientManager) applyOverrides() {
+	now := time.Now().In(cm.location)
+
+	cm.mux.Lock()
+	var expired []int64
+	changed := make(map[string]bool)
+	for id, o := range cm.overrides {
+		if o.expired(now) {
+			expired = append(expired, id)
+			delete(cm.overrides, id)
+		}
+		if o.activeAt(now) != cm.active[id] {
+			changed[o.ClientID] = true
+		}
+	}
+
+	active := make(map[int64]bool)
+	for id, o := range cm.overrides {
+		if o.activeAt(now) {
+			active[id] = true
+		}
+	}
+	cm.active = active
+	updates := cm.refreshLocked(changed)
+	cm.mux.Unlock()
+
+	if len(expired) > 0 && cm.overrideStorage != nil && !cm.ReadOnly() {
+		ctx, cancel := context.WithTimeout(context.Background(), expiredOverrideTimeout)
+		for _, id := range expired {
+			if err := cm.overrideStorage.DeleteOverride(ctx, id); err != nil {
+				log.Printf("Failed to delete expired override %d: %v", id, err)
+			}
+		}
+		cancel()
+	}
+	cm.applyUpdates(updates)
+}
+
+// refreshLocked recomputes the effective config of the given clients and
+// returns the ones whose buckets need reshaping.
+func (cm *ClientManager) refreshLocked(clientIDs map[string]bool) []*ClientConfig {
+	var updates []*ClientConfig
+	for clientID := range clientIDs {
+		client, exists := cm.clients[clientID]
+		if !exists {
+			continue
+		}
+		effective := cm.resolveLocked(client)
+		cm.effective[clientID] = effective
+		updates = append(updates, effective)
+	}
+	return updates
+}
+
+func (cm *ClientManager) applyUpdates(updates []*ClientConfig) {
+	if cm.rateLimiter == nil {
+		return
+	}
+	for _, config := range updates {
+		log.Printf("Limits for client %s are now capacity %d, rate %d", config.ClientID, config.Capacity, config.RatePerSec)
+		cm.rateLimiter.UpdateBucket(config.ClientID, config)
+	}
+}
+
+func (cm *ClientManager) AddOverride(ctx context.Context, o *Override) error {
+	if cm.overrideStorage == nil {
+		return ErrOverridesDisabled
+	}
+	if err := o.Validate(); err != nil {
+		return err
+	}
+	if _, exists := cm.GetClient(o.ClientID); !exists {
+		return fmt.Errorf("client not found")
+	}
+
+	o.CreatedAt = time.Now()
+	if err := cm.overrideStorage.SaveOverride(ctx, o); err != nil {
+		return err
+	}
+
+	cm.mux.Lock()
+	cm.overrides[o.ID] = o
+	cm.mux.Unlock()
+
+	cm.applyOverrides()
+	return nil
+}
+
+func (cm *ClientManager) RemoveOverride(ctx context.Context, id int64) error {
+	if cm.overrideStorage == nil {
+		return ErrOverridesDisabled
+	}
+
+	cm.mux.RLock()
+	_, exists := cm.overrides[id]
+	cm.mux.RUnlock()
+	if !exists {
+		return ErrOverrideNotFound
+	}
+
+	if err := cm.overrideStorage.DeleteOverride(ctx, id); err != nil {
+		return err
+	}
+
+	cm.mux.Lock()
+	o := cm.overrides[id]
+	delete(cm.overrides, id)
+	var updates []*ClientConfig
+	if o != nil && cm.active[id] {
+		delete(cm.active, id)
+		updates = cm.refreshLocked(map[string]bool{o.ClientID: true})
+	}
+	cm.mux.Unlock()
+
+	cm.applyUpdates(updates)
+	return nil
+}
+
+// Overrides lists active and upcoming overrides, optionally for a single
+// client, soonest first.
+func (cm *ClientManager) Overrides(clientID string) []OverrideStatus {
+	now := time.Now().In(cm.location)
+
+	cm.mux.RLock()
+	defer cm.mux.RUnlock()
+
+	statuses := make([]OverrideStatus, 0)
+	for id, o := range cm.overrides {
+		if clientID != "" && o.ClientID != clientID {
+			continue
+		}
+		status := OverrideStatus{Override: o, Active: cm.active[id]}
+		if next, ok := o.nextStart(now); ok {
+			status.NextStart = &next
+		} else if !status.Active {
+			continue
+		}
+		statuses = append(statuses, status)
+	}
+
+	sort.Slice(statuses, func(i, j int) bool {
+		if statuses[i].Active != statuses[j].Active {
+			return statuses[i].Active
+		}
+		if statuses[i].NextStart == nil || statuses[j].NextStart == nil {
+			return statuses[j].NextStart == nil && statuses[i].NextStart != nil
+		}
+		return statuses[i].NextStart.Before(*statuses[j].NextStart)
+	})
+	return statuses
+}
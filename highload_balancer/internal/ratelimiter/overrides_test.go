@@ -0,0 +1,55 @@
+package ratelimiter
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// readOnlyOverrides serves overrides but refuses to change them.
+type readOnlyOverrides struct {
+	*BoltStorage
+	deletes int
+}
+
+func (s *readOnlyOverrides) DeleteOverride(ctx context.Context, id int64) error {
+	s.deletes++
+	return ErrReadOnlyStorage
+}
+
+func (s *readOnlyOverrides) ReadOnly() bool {
+	return true
+}
+
+func TestExpiredOverridesKeptOnReadOnlyStorage(t *testing.T) {
+	bolt, err := NewBoltStorage(filepath.Join(t.TempDir(), "clients.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer bolt.Close()
+
+	ctx := context.Background()
+	if err := bolt.SaveClient(ctx, &ClientConfig{ClientID: "client", Capacity: 10}); err != nil {
+		t.Fatal(err)
+	}
+	ends := time.Now().Add(-time.Minute)
+	if err := bolt.SaveOverride(ctx, &Override{ClientID: "client", Capacity: 5, EndsAt: &ends}); err != nil {
+		t.Fatal(err)
+	}
+
+	storage := &readOnlyOverrides{BoltStorage: bolt}
+	cm := NewClientManager(storage, nil)
+	for i := 0; i < 3; i++ {
+		cm.applyOverrides()
+		if err := cm.Resync(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if storage.deletes != 0 {
+		t.Fatalf("%d deletes attempted on read-only storage", storage.deletes)
+	}
+	if config, _ := cm.GetClientConfig("client"); config.Capacity != 10 {
+		t.Fatalf("capacity %d with only an expired override, want 10", config.Capacity)
+	}
+}
@@ -0,0 +1,96 @@
+package ratelimiter
+
+import (
+	"fmt"
+	"net/netip"
+	"path"
+	"sort"
+	"strings"
+)
+
+// A client ID is either an exact ID (usually an IP), a CIDR such as
+// "10.0.0.0/24" or "2001:db8::/64", or a glob such as "10.0.*" matched
+// with path.Match. Lookups try the exact ID first, then the longest
+// matching CIDR, then the longest matching glob.
+
+func isCIDR(clientID string) bool {
+	return strings.Contains(clientID, "/")
+}
+
+func isGlob(clientID string) bool {
+	return strings.ContainsAny(clientID, "*?[")
+}
+
+// ValidateClientID checks that a CIDR or glob client ID can be parsed.
+func ValidateClientID(clientID string) error {
+	switch {
+	case isCIDR(clientID):
+		if _, err := netip.ParsePrefix(clientID); err != nil {
+			return fmt.Errorf("invalid CIDR client_id %q: %w", clientID, err)
+		}
+	case isGlob(clientID):
+		if _, err := path.Match(clientID, ""); err != nil {
+			return fmt.Errorf("invalid pattern client_id %q: %w", clientID, err)
+		}
+	}
+	return nil
+}
+
+// BucketKey returns the key clientID's requests are limited under when
+// matched to c: the pattern itself if the matched addresses share one
+// bucket, else the address.
+func (c *ClientConfig) BucketKey(clientID string) string {
+	if c.SharedBucket {
+		return c.ClientID
+	}
+	return clientID
+}
+
+// clientPatterns indexes CIDR and glob client IDs. It is rebuilt on every
+// change to the client set, which is rare next to lookups.
+type clientPatterns struct {
+	cidrs *prefixTrie[string]
+	globs []string
+}
+
+func newClientPatterns(clients map[string]*ClientConfig) *clientPatterns {
+	p := &clientPatterns{cidrs: newPrefixTrie[string]()}
+	for id := range clients {
+		switch {
+		case isCIDR(id):
+			if prefix, err := netip.ParsePrefix(id); err == nil {
+				p.cidrs.insert(prefix, id)
+			}
+		case isGlob(id):
+			p.globs = append(p.globs, id)
+		}
+	}
+	// Longer patterns are taken as more specific.
+	sort.Slice(p.globs, func(i, j int) bool {
+		if len(p.globs[i]) != len(p.globs[j]) {
+			return len(p.globs[i]) > len(p.globs[j])
+		}
+		return p.globs[i] < p.globs[j]
+	})
+	return p
+}
+
+func (p *clientPatterns) match(clientID string) (string, bool) {
+	var matched string
+	if addr, err := netip.ParseAddr(clientID); err == nil {
+		p.cidrs.lookup(addr, func(id string) bool {
+			matched = id
+			return true
+		})
+		if matched != "" {
+			return matched, true
+		}
+	}
+
+	for _, glob := range p.globs {
+		if ok, _ := path.Match(glob, clientID); ok {
+			return glob, true
+		}
+	}
+	return "", false
+}
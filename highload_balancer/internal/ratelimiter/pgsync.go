@@ -0,0 +1,279 @@
+package ratelimiter
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// stateCleanupInterval is how often rows of buckets that have refilled
+// completely are deleted.
+const stateCleanupInterval = time.Minute
+
+type syncedBucket struct {
+	capacity int
+	rate     int
+	// tokens is the balance of the shared row at the last sync, refilled
+	// and less what was consumed here since.
+	tokens     float64
+	lastRefill time.Time
+	lastSeen   time.Time
+	pending    float64
+	mux        sync.Mutex
+}
+
+// PostgresSyncStore enforces limits from a local copy of each bucket and
+// periodically folds the tokens consumed on this replica into a shared row.
+// Between syncs each replica admits against the balance it last synced,
+// and may run it into debt by up to maxOvershoot (a fraction of capacity),
+// so replicas together can over-admit until the next sync.
+type PostgresSyncStore struct {
+	storage      *PostgresStorage
+	period       time.Duration
+	maxOvershoot float64
+	buckets      map[string]*syncedBucket
+	mux          sync.Mutex
+	stopChan     chan struct{}
+	doneChan     chan struct{}
+}
+
+func NewPostgresSyncStore(storage *PostgresStorage, period time.Duration, maxOvershoot float64) *PostgresSyncStore {
+	if period <= 0 {
+		period = 200 * time.Millisecond
+	}
+	maxOvershoot = max(maxOvershoot, 0)
+
+	s := &PostgresSyncStore{
+		storage:      storage,
+		period:       period,
+		maxOvershoot: maxOvershoot,
+		buckets:      make(map[string]*syncedBucket),
+		stopChan:     make(chan struct{}),
+		doneChan:     make(chan struct{}),
+	}
+	go s.syncLoop()
+	return s
+}
+
+// Allow never blocks on the database; the algorithm is always a token
+// bucket because that is the state the shared row holds.
+func (s *PostgresSyncStore) Allow(ctx context.Context, key string, algorithm AlgorithmType, capacity, rate, cost int) (bool, float64, error) {
+	now := time.Now()
+
+	s.mux.Lock()
+	b, exists := s.buckets[key]
+	if !exists {
+		b = &syncedBucket{tokens: float64(capacity), lastRefill: now}
+		s.buckets[key] = b
+	}
+	s.mux.Unlock()
+
+	b.mux.Lock()
+	defer b.mux.Unlock()
+
+	b.capacity = capacity
+	b.rate = rate
+	b.lastSeen = now
+	if elapsed := now.Sub(b.lastRefill).Seconds(); elapsed > 0 {
+		b.tokens = min(float64(capacity), b.tokens+elapsed*float64(rate))
+		b.lastRefill = now
+	}
+
+	n := float64(cost)
+	if cost > capacity || b.tokens-n < -s.maxOvershoot*float64(capacity) {
+		return false, max(b.tokens, 0), nil
+	}
+	b.tokens -= n
+	b.pending += n
+	return true, max(b.tokens, 0), nil
+}
+
+// Refund credits the local balance and reports the tokens back as
+// negative consumption with the next sync.
+func (s *PostgresSyncStore) Refund(ctx context.Context, key string, algorithm AlgorithmType, capacity, rate, cost int) error {
+	s.mux.Lock()
+	b, exists := s.buckets[key]
+	s.mux.Unlock()
+	if !exists {
+		return nil
+	}
+
+	b.mux.Lock()
+	defer b.mux.Unlock()
+	b.tokens = min(float64(capacity), b.tokens+float64(cost))
+	b.pending -= float64(cost)
+	return nil
+}
+
+func (s *PostgresSyncStore) Reset(ctx context.Context, key string) error {
+	s.mux.Lock()
+	delete(s.buckets, key)
+	s.mux.Unlock()
+
+	_, err := s.storage.db.ExecContext(ctx, "DELETE FROM rate_limit_state WHERE key = $1", key)
+	return err
+}
+
+func (s *PostgresSyncStore) Close() error {
+	close(s.stopChan)
+	<-s.doneChan
+	return nil
+}
+
+func (s *PostgresSyncStore) syncLoop() {
+	defer close(s.doneChan)
+
+	ticker := time.NewTicker(s.period)
+	defer ticker.Stop()
+	cleanup := time.NewTicker(stateCleanupInterval)
+	defer cleanup.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := s.sync(); err != nil {
+				log.Printf("Failed to sync rate limit state: %v", err)
+			}
+		case <-cleanup.C:
+			if err := s.cleanup(); err != nil {
+				log.Printf("Failed to clean up rate limit state: %v", err)
+			}
+		case <-s.stopChan:
+			if err := s.sync(); err != nil {
+				log.Printf("Failed to flush rate limit state: %v", err)
+			}
+			return
+		}
+	}
+}
+
+func (s *PostgresSyncStore) sync() error {
+	now := time.Now()
+
+	s.mux.Lock()
+	var (
+		keys       []string
+		capacities []float64
+		rates      []float64
+		consumed   []float64
+	)
+	for key, b := range s.buckets {
+		b.mux.Lock()
+		// A bucket with nothing to report that has had time to refill
+		// completely carries no information worth keeping.
+		if b.pending == 0 && now.Sub(b.lastSeen) > windowFor(float64(b.capacity), float64(b.rate)) {
+			delete(s.buckets, key)
+			b.mux.Unlock()
+			continue
+		}
+		keys = append(keys, key)
+		capacities = append(capacities, float64(b.capacity))
+		rates = append(rates, float64(b.rate))
+		consumed = append(consumed, b.pending)
+		b.pending = 0
+		b.mux.Unlock()
+	}
+	s.mux.Unlock()
+
+	if len(keys) == 0 {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), s.period)
+	defer cancel()
+
+	rows, err := s.storage.db.QueryContext(ctx, `
+	INSERT INTO rate_limit_state AS s (key, capacity, rate, tokens, updated_at)
+	SELECT k, c, r, c - u, NOW()
+	FROM unnest($1::text[], $2::float8[], $3::float8[], $4::float8[]) AS t(k, c, r, u)
+	ON CONFLICT (key) DO UPDATE SET
+		capacity = EXCLUDED.capacity,
+		rate = EXCLUDED.rate,
+		tokens = LEAST(EXCLUDED.capacity,
+			LEAST(EXCLUDED.capacity, s.tokens + EXTRACT(EPOCH FROM NOW() - s.updated_at) * EXCLUDED.rate)
+			- (EXCLUDED.capacity - EXCLUDED.tokens)),
+		updated_at = NOW()
+	RETURNING key, tokens
+	`, pq.Array(keys), pq.Array(capacities), pq.Array(rates), pq.Array(consumed))
+	if err != nil {
+		s.restore(keys, consumed, nil)
+		return err
+	}
+	defer rows.Close()
+
+	applied := make(map[string]bool, len(keys))
+	for rows.Next() {
+		var (
+			key    string
+			tokens float64
+		)
+		if err = rows.Scan(&key, &tokens); err != nil {
+			break
+		}
+		s.apply(key, tokens)
+		applied[key] = true
+	}
+	if err == nil {
+		err = rows.Err()
+	}
+	if err != nil {
+		// Consumption not confirmed by the database is reported again
+		// with the next sync rather than lost.
+		s.restore(keys, consumed, applied)
+	}
+	return err
+}
+
+// cleanup deletes rows that have had time to refill completely, like the
+// TTL of the Redis keys; a missing row starts out full anyway. Rows
+// without a rate never refill and are kept.
+func (s *PostgresSyncStore) cleanup() error {
+	ctx, cancel := s.storage.withTimeout(context.Background())
+	defer cancel()
+
+	_, err := s.storage.db.ExecContext(ctx, `
+	DELETE FROM rate_limit_state
+	WHERE rate > 0 AND updated_at < NOW() - make_interval(secs => capacity / rate + 1)
+	`)
+	return err
+}
+
+// apply replaces the local balance with the shared one, minus whatever
+// was consumed here after the snapshot was taken.
+func (s *PostgresSyncStore) apply(key string, shared float64) {
+	s.mux.Lock()
+	b, exists := s.buckets[key]
+	s.mux.Unlock()
+	if !exists {
+		return
+	}
+
+	b.mux.Lock()
+	// Refunds reported as negative consumption can take a new row past
+	// capacity.
+	b.tokens = min(shared, float64(b.capacity)) - b.pending
+	b.lastRefill = time.Now()
+	b.mux.Unlock()
+}
+
+// restore puts back consumption that failed to sync, except for the keys
+// already applied. Meanwhile buckets keep enforcing from their local
+// balance, so a database outage degrades to local enforcement.
+func (s *PostgresSyncStore) restore(keys []string, consumed []float64, applied map[string]bool) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	for i, key := range keys {
+		if applied[key] {
+			continue
+		}
+		if b, exists := s.buckets[key]; exists {
+			b.mux.Lock()
+			b.pending += consumed[i]
+			b.mux.Unlock()
+		}
+	}
+}
@@ -0,0 +1,72 @@
+package ratelimiter
+
+import (
+	"context"
+	"testing"
+)
+
+// newLocalSyncStore returns a store that is never synced, to check
+// admission between syncs.
+func newLocalSyncStore(maxOvershoot float64) *PostgresSyncStore {
+	return &PostgresSyncStore{
+		maxOvershoot: maxOvershoot,
+		buckets:      make(map[string]*syncedBucket),
+	}
+}
+
+func admitted(t *testing.T, s *PostgresSyncStore, capacity, cost, requests int) int {
+	t.Helper()
+	n := 0
+	for i := 0; i < requests; i++ {
+		allowed, _, err := s.Allow(context.Background(), "client", TokenBucketAlgorithm, capacity, 0, cost)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if allowed {
+			n++
+		}
+	}
+	return n
+}
+
+func TestPostgresSyncAdmission(t *testing.T) {
+	for _, c := range []struct {
+		name                  string
+		overshoot             float64
+		capacity, cost, count int
+		want                  int
+	}{
+		{"small capacity", 0.2, 2, 1, 10, 2},
+		{"capacity one", 0.2, 1, 1, 10, 1},
+		{"cost above overshoot", 0.2, 10, 5, 10, 2},
+		{"overshoot into debt", 0.5, 10, 1, 20, 15},
+		{"no overshoot", 0, 10, 3, 10, 3},
+		{"cost above capacity", 1, 10, 11, 1, 0},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			s := newLocalSyncStore(c.overshoot)
+			if got := admitted(t, s, c.capacity, c.cost, c.count); got != c.want {
+				t.Fatalf("admitted %d requests, want %d", got, c.want)
+			}
+		})
+	}
+}
+
+func TestPostgresSyncRestore(t *testing.T) {
+	s := newLocalSyncStore(0)
+	admitted(t, s, 10, 1, 3)
+
+	b := s.buckets["client"]
+	consumed := []float64{b.pending}
+	b.pending = 0
+	s.restore([]string{"client"}, consumed, map[string]bool{})
+	if b.pending != 3 {
+		t.Fatalf("pending after restore = %v, want 3", b.pending)
+	}
+
+	b.pending = 0
+	s.restore([]string{"client"}, consumed, map[string]bool{"client": true})
+	if b.pending != 0 {
+		t.Fatalf("pending after restore of an applied key = %v, want 0", b.pending)
+	}
+}
@@ -0,0 +1,154 @@
+package ratelimiter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+)
+
+var (
+	ErrPlanNotFound  = errors.New("plan not found")
+	ErrPlanInUse     = errors.New("plan is referenced by clients")
+	ErrPlansDisabled = errors.New("storage does not support plans")
+)
+
+type Plan struct {
+	Name         string        `json:"name"`
+	Capacity     int           `json:"capacity"`
+	RatePerSec   int           `json:"rate_per_sec"`
+	Algorithm    AlgorithmType `json:"algorithm,omitempty"`
+	DailyQuota   int64         `json:"daily_quota,omitempty"`
+	MonthlyQuota int64         `json:"monthly_quota,omitempty"`
+	CreatedAt    time.Time     `json:"created_at"`
+	LastUpdated  time.Time     `json:"last_updated"`
+}
+
+type PlanStorage interface {
+	SavePlan(context.Context, *Plan) error
+	DeletePlan(context.Context, string) error
+	GetAllPlans(context.Context) (map[string]*Plan, error)
+}
+
+func (cm *ClientManager) loadInitialPlans() {
+	if cm.planStorage == nil {
+		return
+	}
+
+	plans, err := cm.planStorage.GetAllPlans(context.Background())
+	if err != nil {
+		log.Printf("Failed to load initial plans: %v", err)
+		cm.stale = true
+		return
+	}
+
+	cm.mux.Lock()
+	defer cm.mux.Unlock()
+	cm.plans = plans
+}
+
+func (cm *ClientManager) checkPlan(name string) error {
+	if name == "" {
+		return nil
+	}
+
+	cm.mux.RLock()
+	defer cm.mux.RUnlock()
+	if _, exists := cm.plans[name]; !exists {
+		return fmt.Errorf("%w: %s", ErrPlanNotFound, name)
+	}
+	return nil
+}
+
+func (cm *ClientManager) GetAllPlans() map[string]*Plan {
+	cm.mux.RLock()
+	defer cm.mux.RUnlock()
+
+	plans := make(map[string]*Plan)
+	for name, plan := range cm.plans {
+		p := *plan
+		plans[name] = &p
+	}
+	return plans
+}
+
+func (cm *ClientManager) GetPlan(name string) (*Plan, bool) {
+	cm.mux.RLock()
+	defer cm.mux.RUnlock()
+	plan, exists := cm.plans[name]
+	return plan, exists
+}
+
+// SavePlan creates or replaces a plan and reshapes the live buckets of
+// every client on it.
+func (cm *ClientManager) SavePlan(ctx context.Context, plan *Plan) error {
+	if cm.planStorage == nil {
+		return ErrPlansDisabled
+	}
+
+	now := time.Now()
+	cm.mux.RLock()
+	if existing, exists := cm.plans[plan.Name]; exists {
+		plan.CreatedAt = existing.CreatedAt
+	} else {
+		plan.CreatedAt = now
+	}
+	cm.mux.RUnlock()
+	plan.LastUpdated = now
+
+	if err := cm.planStorage.SavePlan(ctx, plan); err != nil {
+		return fmt.Errorf("database error: %w", err)
+	}
+
+	cm.mux.Lock()
+	cm.plans[plan.Name] = plan
+	updated := make(map[string]*ClientConfig)
+	for id, client := range cm.clients {
+		if client.Plan == plan.Name {
+			cm.effective[id] = cm.resolveLocked(client)
+			updated[id] = cm.effective[id]
+		}
+	}
+	cm.mux.Unlock()
+
+	if cm.rateLimiter != nil {
+		for id, effective := range updated {
+			cm.rateLimiter.UpdateBucket(id, effective)
+		}
+	}
+	return nil
+}
+
+func (cm *ClientManager) RemovePlan(ctx context.Context, name string) error {
+	if cm.planStorage == nil {
+		return ErrPlansDisabled
+	}
+
+	cm.mux.RLock()
+	_, exists := cm.plans[name]
+	inUse := false
+	for _, client := range cm.clients {
+		if client.Plan == name {
+			inUse = true
+			break
+		}
+	}
+	cm.mux.RUnlock()
+
+	if !exists {
+		return ErrPlanNotFound
+	}
+	if inUse {
+		return ErrPlanInUse
+	}
+
+	if err := cm.planStorage.DeletePlan(ctx, name); err != nil {
+		return fmt.Errorf("database error: %w", err)
+	}
+
+	cm.mux.Lock()
+	delete(cm.plans, name)
+	cm.mux.Unlock()
+	return nil
+}
@@ -3,98 +3,205 @@ package ratelimiter
 import (
 	"context"
 	"database/sql"
+	"encoding/json"
 	"fmt"
+	"log"
 	"time"
 
-	_ "github.com/lib/pq"
+	"github.com/lib/pq"
+	"github.com/se1y4/highload-balancer/internal/migrate"
+	"github.com/se1y4/highload-balancer/migrations"
 )
 
+const clientsChannel = "clients_changed"
+
 type ClientStorage interface {
-	SaveClient(*ClientConfig) error
-	DeleteClient(string) error
-	GetClient(string) (*ClientConfig, error)
-	GetAllClients() (map[string]*ClientConfig, error)
+	SaveClient(context.Context, *ClientConfig) error
+	DeleteClient(context.Context, string) error
+	GetClient(context.Context, string) (*ClientConfig, error)
+	GetAllClients(context.Context) (map[string]*ClientConfig, error)
 	Close() error
 }
 
+// PostgresConfig bounds every query by QueryTimeout, besides the
+// caller's context. Pool limits left at zero are unlimited.
+type PostgresConfig struct {
+	ConnString      string
+	QueryTimeout    time.Duration
+	MaxOpenConns    int
+	MaxIdleConns    int
+	ConnMaxLifetime time.Duration
+}
+
 type PostgresStorage struct {
-	db *sql.DB
+	db           *sql.DB
+	connStr      string
+	queryTimeout time.Duration
+	health       storageHealth
 }
 
-func NewPostgresStorage(connStr string) (*PostgresStorage, error) {
-	db, err := sql.Open("postgres", connStr)
+// NewPostgresStorage fails only on an invalid configuration. An
+// unreachable server is reported by Status; Monitor keeps retrying.
+func NewPostgresStorage(config *PostgresConfig) (*PostgresStorage, error) {
+	db, err := sql.Open("postgres", config.ConnString)
 	if err != nil {
 		return nil, fmt.Errorf("failed to connect to PostgreSQL: %w", err)
 	}
+	db.SetMaxOpenConns(config.MaxOpenConns)
+	if config.MaxIdleConns > 0 {
+		db.SetMaxIdleConns(config.MaxIdleConns)
+	}
+	db.SetConnMaxLifetime(config.ConnMaxLifetime)
 
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
-
-	if err := db.PingContext(ctx); err != nil {
-		return nil, fmt.Errorf("failed to ping PostgreSQL: %w", err)
+	s := &PostgresStorage{
+		db:           db,
+		connStr:      config.ConnString,
+		queryTimeout: config.QueryTimeout,
 	}
+	s.health.set(s.ping())
+	return s, nil
+}
 
-	return &PostgresStorage{db: db}, nil
+func (s *PostgresStorage) withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if s.queryTimeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, s.queryTimeout)
 }
 
+// InitSchema applies pending migrations. Replicas starting together
+// wait for each other instead of racing.
 func (s *PostgresStorage) InitSchema() error {
-	query := `
-	CREATE TABLE IF NOT EXISTS clients (
-		client_id TEXT PRIMARY KEY,
-		capacity INTEGER NOT NULL,
-		rate_per_sec INTEGER NOT NULL,
-		created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
-		updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
-	);
-	
-	CREATE INDEX IF NOT EXISTS idx_clients_updated ON clients(updated_at);
-	`
-	_, err := s.db.Exec(query)
+	m, err := s.Migrator()
+	if err != nil {
+		return err
+	}
+	applied, err := m.Up(context.Background())
+	for _, mig := range applied {
+		log.Printf("Applied migration %d_%s", mig.Version, mig.Name)
+	}
 	return err
 }
 
-func (s *PostgresStorage) SaveClient(client *ClientConfig) error {
+func (s *PostgresStorage) Migrator() (*migrate.Migrator, error) {
+	return migrate.New(s.db, migrations.FS)
+}
+
+func (s *PostgresStorage) SaveClient(ctx context.Context, client *ClientConfig) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
 	query := `
-	INSERT INTO clients (client_id, capacity, rate_per_sec)
-	VALUES ($1, $2, $3)
-	ON CONFLICT (client_id) 
-	DO UPDATE SET 
+	INSERT INTO clients (client_id, capacity, rate_per_sec, algorithm, daily_quota, monthly_quota, quota_reset_day, plan, routes, max_concurrent, queue_depth, max_wait_ms, priority, shared_bucket, created_at, updated_at)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16)
+	ON CONFLICT (client_id)
+	DO UPDATE SET
 		capacity = EXCLUDED.capacity,
 		rate_per_sec = EXCLUDED.rate_per_sec,
-		updated_at = NOW()
+		algorithm = EXCLUDED.algorithm,
+		daily_quota = EXCLUDED.daily_quota,
+		monthly_quota = EXCLUDED.monthly_quota,
+		quota_reset_day = EXCLUDED.quota_reset_day,
+		plan = EXCLUDED.plan,
+		routes = EXCLUDED.routes,
+		max_concurrent = EXCLUDED.max_concurrent,
+		queue_depth = EXCLUDED.queue_depth,
+		max_wait_ms = EXCLUDED.max_wait_ms,
+		priority = EXCLUDED.priority,
+		shared_bucket = EXCLUDED.shared_bucket,
+		updated_at = EXCLUDED.updated_at
 	`
-	_, err := s.db.Exec(query, 
-		client.ClientID, 
-		client.Capacity, 
+	routes, err := marshalRoutes(client.Routes)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.db.ExecContext(ctx, query,
+		client.ClientID,
+		client.Capacity,
 		client.RatePerSec,
+		client.Algorithm,
+		client.DailyQuota,
+		client.MonthlyQuota,
+		client.QuotaResetDay,
+		client.Plan,
+		routes,
+		client.MaxConcurrent,
+		client.QueueDepth,
+		client.MaxWaitMs,
+		client.Priority,
+		client.SharedBucket,
+		client.CreatedAt,
+		client.LastUpdated,
 	)
 	return err
 }
 
-func (s *PostgresStorage) DeleteClient(clientID string) error {
-	_, err := s.db.Exec(
-		"DELETE FROM clients WHERE client_id = $1", 
+// marshalRoutes encodes routes as a string: lib/pq sends []byte in binary
+// format, which jsonb rejects.
+func marshalRoutes(routes []RouteRule) (string, error) {
+	if routes == nil {
+		routes = []RouteRule{}
+	}
+	b, err := json.Marshal(routes)
+	return string(b), err
+}
+
+func (s *PostgresStorage) DeleteClient(ctx context.Context, clientID string) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	_, err := s.db.ExecContext(ctx,
+		"DELETE FROM clients WHERE client_id = $1",
 		clientID,
 	)
 	return err
 }
 
-func (s *PostgresStorage) GetClient(clientID string) (*ClientConfig, error) {
-	var config ClientConfig
-	
-	err := s.db.QueryRow(`
-		SELECT 
-			client_id, 
-			capacity, 
-			rate_per_sec, 
-			created_at, 
-			updated_at 
-		FROM clients 
+func (s *PostgresStorage) GetClient(ctx context.Context, clientID string) (*ClientConfig, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	var (
+		config ClientConfig
+		routes []byte
+	)
+
+	err := s.db.QueryRowContext(ctx, `
+		SELECT
+			client_id,
+			capacity,
+			rate_per_sec,
+			algorithm,
+			daily_quota,
+			monthly_quota,
+			quota_reset_day,
+			plan,
+			routes,
+			max_concurrent,
+			queue_depth,
+			max_wait_ms,
+			priority,
+			shared_bucket,
+			created_at,
+			updated_at
+		FROM clients
 		WHERE client_id = $1
 	`, clientID).Scan(
 		&config.ClientID,
 		&config.Capacity,
 		&config.RatePerSec,
+		&config.Algorithm,
+		&config.DailyQuota,
+		&config.MonthlyQuota,
+		&config.QuotaResetDay,
+		&config.Plan,
+		&routes,
+		&config.MaxConcurrent,
+		&config.QueueDepth,
+		&config.MaxWaitMs,
+		&config.Priority,
+		&config.SharedBucket,
 		&config.CreatedAt,
 		&config.LastUpdated,
 	)
@@ -105,17 +212,34 @@ func (s *PostgresStorage) GetClient(clientID string) (*ClientConfig, error) {
 		}
 		return nil, err
 	}
+	if err := json.Unmarshal(routes, &config.Routes); err != nil {
+		return nil, err
+	}
 	return &config, nil
 }
 
-func (s *PostgresStorage) GetAllClients() (map[string]*ClientConfig, error) {
-	rows, err := s.db.Query(`
-		SELECT 
-			client_id, 
-			capacity, 
-			rate_per_sec, 
-			created_at, 
-			updated_at 
+func (s *PostgresStorage) GetAllClients(ctx context.Context) (map[string]*ClientConfig, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT
+			client_id,
+			capacity,
+			rate_per_sec,
+			algorithm,
+			daily_quota,
+			monthly_quota,
+			quota_reset_day,
+			plan,
+			routes,
+			max_concurrent,
+			queue_depth,
+			max_wait_ms,
+			priority,
+			shared_bucket,
+			created_at,
+			updated_at
 		FROM clients
 	`)
 	if err != nil {
@@ -125,16 +249,33 @@ func (s *PostgresStorage) GetAllClients() (map[string]*ClientConfig, error) {
 
 	clients := make(map[string]*ClientConfig)
 	for rows.Next() {
-		var config ClientConfig
+		var (
+			config ClientConfig
+			routes []byte
+		)
 		if err := rows.Scan(
 			&config.ClientID,
 			&config.Capacity,
 			&config.RatePerSec,
+			&config.Algorithm,
+			&config.DailyQuota,
+			&config.MonthlyQuota,
+			&config.QuotaResetDay,
+			&config.Plan,
+			&routes,
+			&config.MaxConcurrent,
+			&config.QueueDepth,
+			&config.MaxWaitMs,
+			&config.Priority,
+			&config.SharedBucket,
 			&config.CreatedAt,
 			&config.LastUpdated,
 		); err != nil {
 			return nil, err
 		}
+		if err := json.Unmarshal(routes, &config.Routes); err != nil {
+			return nil, err
+		}
 		clients[config.ClientID] = &config
 	}
 
@@ -145,6 +286,392 @@ func (s *PostgresStorage) GetAllClients() (map[string]*ClientConfig, error) {
 	return clients, nil
 }
 
+func (s *PostgresStorage) SavePlan(ctx context.Context, plan *Plan) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	_, err := s.db.ExecContext(ctx, `
+	INSERT INTO plans (name, capacity, rate_per_sec, algorithm, daily_quota, monthly_quota)
+	VALUES ($1, $2, $3, $4, $5, $6)
+	ON CONFLICT (name)
+	DO UPDATE SET
+		capacity = EXCLUDED.capacity,
+		rate_per_sec = EXCLUDED.rate_per_sec,
+		algorithm = EXCLUDED.algorithm,
+		daily_quota = EXCLUDED.daily_quota,
+		monthly_quota = EXCLUDED.monthly_quota,
+		updated_at = NOW()
+	`,
+		plan.Name,
+		plan.Capacity,
+		plan.RatePerSec,
+		plan.Algorithm,
+		plan.DailyQuota,
+		plan.MonthlyQuota,
+	)
+	return err
+}
+
+func (s *PostgresStorage) DeletePlan(ctx context.Context, name string) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	_, err := s.db.ExecContext(ctx, "DELETE FROM plans WHERE name = $1", name)
+	return err
+}
+
+func (s *PostgresStorage) GetAllPlans(ctx context.Context) (map[string]*Plan, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT
+			name,
+			capacity,
+			rate_per_sec,
+			algorithm,
+			daily_quota,
+			monthly_quota,
+			created_at,
+			updated_at
+		FROM plans
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	plans := make(map[string]*Plan)
+	for rows.Next() {
+		var plan Plan
+		if err := rows.Scan(
+			&plan.Name,
+			&plan.Capacity,
+			&plan.RatePerSec,
+			&plan.Algorithm,
+			&plan.DailyQuota,
+			&plan.MonthlyQuota,
+			&plan.CreatedAt,
+			&plan.LastUpdated,
+		); err != nil {
+			return nil, err
+		}
+		plans[plan.Name] = &plan
+	}
+	return plans, rows.Err()
+}
+
+func (s *PostgresStorage) SaveACLEntry(ctx context.Context, entry *ACLEntry) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	_, err := s.db.ExecContext(ctx, `
+	INSERT INTO acl_entries (cidr, action, comment, expires_at, created_at)
+	VALUES ($1, $2, $3, $4, $5)
+	ON CONFLICT (cidr)
+	DO UPDATE SET
+		action = EXCLUDED.action,
+		comment = EXCLUDED.comment,
+		expires_at = EXCLUDED.expires_at,
+		created_at = EXCLUDED.created_at
+	`,
+		entry.CIDR,
+		entry.Action,
+		entry.Comment,
+		entry.ExpiresAt,
+		entry.CreatedAt,
+	)
+	return err
+}
+
+func (s *PostgresStorage) DeleteACLEntry(ctx context.Context, cidr string) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	_, err := s.db.ExecContext(ctx, "DELETE FROM acl_entries WHERE cidr = $1", cidr)
+	return err
+}
+
+func (s *PostgresStorage) GetACLEntries(ctx context.Context) ([]*ACLEntry, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT cidr, action, comment, expires_at, created_at
+		FROM acl_entries
+		WHERE expires_at IS NULL OR expires_at > NOW()
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var entries []*ACLEntry
+	for rows.Next() {
+		var entry ACLEntry
+		if err := rows.Scan(
+			&entry.CIDR,
+			&entry.Action,
+			&entry.Comment,
+			&entry.ExpiresAt,
+			&entry.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		entries = append(entries, &entry)
+	}
+	return entries, rows.Err()
+}
+
+func (s *PostgresStorage) SaveBan(ctx context.Context, ban *Ban) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	_, err := s.db.ExecContext(ctx, `
+	INSERT INTO bans (client_id, offenses, banned_at, banned_until)
+	VALUES ($1, $2, $3, $4)
+	ON CONFLICT (client_id)
+	DO UPDATE SET
+		offenses = EXCLUDED.offenses,
+		banned_at = EXCLUDED.banned_at,
+		banned_until = EXCLUDED.banned_until
+	`,
+		ban.ClientID,
+		ban.Offenses,
+		ban.BannedAt,
+		ban.BannedUntil,
+	)
+	return err
+}
+
+func (s *PostgresStorage) DeleteBan(ctx context.Context, clientID string) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	_, err := s.db.ExecContext(ctx, "DELETE FROM bans WHERE client_id = $1", clientID)
+	return err
+}
+
+func (s *PostgresStorage) GetActiveBans(ctx context.Context) ([]*Ban, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT client_id, offenses, banned_at, banned_until
+		FROM bans
+		WHERE banned_until > NOW()
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var bans []*Ban
+	for rows.Next() {
+		var ban Ban
+		if err := rows.Scan(
+			&ban.ClientID,
+			&ban.Offenses,
+			&ban.BannedAt,
+			&ban.BannedUntil,
+		); err != nil {
+			return nil, err
+		}
+		bans = append(bans, &ban)
+	}
+	return bans, rows.Err()
+}
+
+func (s *PostgresStorage) SaveOverride(ctx context.Context, o *Override) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	return s.db.QueryRowContext(ctx, `
+	INSERT INTO limit_overrides (client_id, capacity, rate_per_sec, starts_at, ends_at, schedule, duration_seconds, comment, created_at)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+	RETURNING id
+	`,
+		o.ClientID,
+		o.Capacity,
+		o.RatePerSec,
+		o.StartsAt,
+		o.EndsAt,
+		o.Schedule,
+		o.DurationSeconds,
+		o.Comment,
+		o.CreatedAt,
+	).Scan(&o.ID)
+}
+
+func (s *PostgresStorage) DeleteOverride(ctx context.Context, id int64) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	_, err := s.db.ExecContext(ctx, "DELETE FROM limit_overrides WHERE id = $1", id)
+	return err
+}
+
+func (s *PostgresStorage) GetAllOverrides(ctx context.Context) ([]*Override, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT
+			id,
+			client_id,
+			capacity,
+			rate_per_sec,
+			starts_at,
+			ends_at,
+			schedule,
+			duration_seconds,
+			comment,
+			created_at
+		FROM limit_overrides
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var overrides []*Override
+	for rows.Next() {
+		var o Override
+		if err := rows.Scan(
+			&o.ID,
+			&o.ClientID,
+			&o.Capacity,
+			&o.RatePerSec,
+			&o.StartsAt,
+			&o.EndsAt,
+			&o.Schedule,
+			&o.DurationSeconds,
+			&o.Comment,
+			&o.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		overrides = append(overrides, &o)
+	}
+	return overrides, rows.Err()
+}
+
+func (s *PostgresStorage) LoadUsage(ctx context.Context, since time.Time) ([]UsageRecord, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT client_id, period, period_start, count
+		FROM client_usage
+		WHERE period_start >= $1
+	`, since)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var records []UsageRecord
+	for rows.Next() {
+		var rec UsageRecord
+		if err := rows.Scan(&rec.ClientID, &rec.Period, &rec.PeriodStart, &rec.Count); err != nil {
+			return nil, err
+		}
+		records = append(records, rec)
+	}
+	return records, rows.Err()
+}
+
+// AddUsage increments usage counters in one statement and returns the
+// resulting totals, which include increments from other replicas.
+func (s *PostgresStorage) AddUsage(ctx context.Context, records []UsageRecord) ([]UsageRecord, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	var (
+		clientIDs = make([]string, len(records))
+		periods   = make([]string, len(records))
+		starts    = make([]string, len(records))
+		counts    = make([]int64, len(records))
+	)
+	for i, rec := range records {
+		clientIDs[i] = rec.ClientID
+		periods[i] = string(rec.Period)
+		starts[i] = rec.PeriodStart.Format(time.RFC3339)
+		counts[i] = rec.Count
+	}
+
+	rows, err := s.db.QueryContext(ctx, `
+	INSERT INTO client_usage AS u (client_id, period, period_start, count)
+	SELECT * FROM unnest($1::text[], $2::text[], $3::timestamptz[], $4::bigint[])
+	ON CONFLICT (client_id, period, period_start)
+	DO UPDATE SET count = u.count + EXCLUDED.count
+	RETURNING client_id, period, period_start, count
+	`, pq.Array(clientIDs), pq.Array(periods), pq.Array(starts), pq.Array(counts))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	totals := make([]UsageRecord, 0, len(records))
+	for rows.Next() {
+		var rec UsageRecord
+		if err := rows.Scan(&rec.ClientID, &rec.Period, &rec.PeriodStart, &rec.Count); err != nil {
+			return nil, err
+		}
+		totals = append(totals, rec)
+	}
+	return totals, rows.Err()
+}
+
+// WatchClients calls onChange with the ID of every client inserted,
+// updated or deleted by any instance, and onResync whenever notifications
+// may have been missed because the listening connection was lost. The
+// returned func stops watching.
+func (s *PostgresStorage) WatchClients(onChange func(clientID string), onResync func()) (func(), error) {
+	listener := pq.NewListener(s.connStr, time.Second, time.Minute, func(event pq.ListenerEventType, err error) {
+		if err != nil {
+			log.Printf("Client change listener: %v", err)
+		}
+	})
+	if err := listener.Listen(clientsChannel); err != nil {
+		listener.Close()
+		return nil, err
+	}
+
+	stopChan := make(chan struct{})
+	doneChan := make(chan struct{})
+	go func() {
+		defer close(doneChan)
+		defer listener.Close()
+
+		// Idle connections can die silently; a ping surfaces it so the
+		// listener reconnects.
+		ping := time.NewTicker(90 * time.Second)
+		defer ping.Stop()
+
+		for {
+			select {
+			case n := <-listener.Notify:
+				// A nil notification follows a reconnect.
+				if n == nil {
+					onResync()
+					continue
+				}
+				onChange(n.Extra)
+			case <-ping.C:
+				go listener.Ping()
+			case <-stopChan:
+				return
+			}
+		}
+	}()
+
+	return func() {
+		close(stopChan)
+		<-doneChan
+	}, nil
+}
+
 func (s *PostgresStorage) Close() error {
 	return s.db.Close()
-}
\ No newline at end of file
+}
@@ -0,0 +1,40 @@
+package ratelimiter_test
+
+import (
+	"database/sql"
+	"os"
+	"testing"
+
+	_ "github.com/lib/pq"
+	"github.com/se1y4/highload-balancer/internal/ratelimiter"
+	"github.com/se1y4/highload-balancer/internal/ratelimiter/storagetest"
+)
+
+// TestPostgresStorage runs against the database in HB_TEST_POSTGRES_DSN,
+// whose tables it empties before every check.
+func TestPostgresStorage(t *testing.T) {
+	dsn := os.Getenv("HB_TEST_POSTGRES_DSN")
+	if dsn == "" {
+		t.Skip("HB_TEST_POSTGRES_DSN is not set")
+	}
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	storagetest.Run(t, func(t *testing.T, clients []*ratelimiter.ClientConfig) ratelimiter.ClientStorage {
+		s, err := ratelimiter.NewPostgresStorage(&ratelimiter.PostgresConfig{ConnString: dsn})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := s.InitSchema(); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := db.Exec(`TRUNCATE clients, plans, client_usage, acl_entries, bans, limit_overrides, rate_limit_state CASCADE`); err != nil {
+			t.Fatal(err)
+		}
+		seed(t, s, clients)
+		return s
+	})
+}
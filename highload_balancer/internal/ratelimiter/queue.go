@@ -0,0 +1,179 @@
+package ratelimiter
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/se1y4/highload-balancer/internal/metrics"
+)
+
+var (
+	ErrQueueFull   = errors.New("rate limit wait queue is full")
+	ErrWaitTimeout = errors.New("rate limit wait timed out")
+)
+
+// minRetryDelay keeps a waiter from spinning when a limiter predicts no
+// delay but still rejects because another request took the budget.
+const minRetryDelay = time.Millisecond
+
+type waitQueue struct {
+	// turn holds a token while the head of the queue owns the bucket.
+	// Blocked senders on a channel are served in FIFO order.
+	turn    chan struct{}
+	waiting int
+}
+
+type waitQueues struct {
+	queues map[string]*waitQueue
+	mux    sync.Mutex
+}
+
+func newWaitQueues() *waitQueues {
+	return &waitQueues{
+		queues: make(map[string]*waitQueue),
+	}
+}
+
+// wait admits the request immediately if nobody is queued for key and
+// try succeeds; otherwise it joins a FIFO queue of at most depth waiters
+// and retries once the head of the queue expects a token, for up to
+// maxWait or until ctx is done. peek describes the bucket for requests
+// turned away before they got to try.
+func (wq *waitQueues) wait(ctx context.Context, key string, depth int, maxWait time.Duration, peek func() Decision, try func() (Decision, time.Duration)) (Decision, error) {
+	wq.mux.Lock()
+	q := wq.queues[key]
+	wq.mux.Unlock()
+
+	var last Decision
+	if q == nil {
+		decision, _ := try()
+		if decision.Allowed {
+			return decision, nil
+		}
+		last = decision
+	} else {
+		last = peek()
+	}
+
+	wq.mux.Lock()
+	q = wq.queues[key]
+	if q == nil {
+		q = &waitQueue{turn: make(chan struct{}, 1)}
+		wq.queues[key] = q
+	}
+	if q.waiting >= depth {
+		wq.mux.Unlock()
+		metrics.QueueOutcomes.Add("full", 1)
+		return last, ErrQueueFull
+	}
+	q.waiting++
+	wq.mux.Unlock()
+	metrics.QueueWaiting.Add(1)
+
+	defer func() {
+		wq.mux.Lock()
+		q.waiting--
+		if q.waiting == 0 {
+			delete(wq.queues, key)
+		}
+		wq.mux.Unlock()
+		metrics.QueueWaiting.Add(-1)
+	}()
+
+	waitCtx, cancel := context.WithTimeout(ctx, maxWait)
+	defer cancel()
+
+	select {
+	case q.turn <- struct{}{}:
+	case <-waitCtx.Done():
+		return last, waitError(ctx)
+	}
+	defer func() { <-q.turn }()
+
+	deadline, _ := waitCtx.Deadline()
+	for {
+		decision, delay := try()
+		if decision.Allowed {
+			metrics.QueueOutcomes.Add("admitted", 1)
+			return decision, nil
+		}
+		last = decision
+
+		// Give up early rather than hold the queue for a token that
+		// cannot arrive in time.
+		delay = max(delay, minRetryDelay)
+		if time.Until(deadline) < delay {
+			metrics.QueueOutcomes.Add("timeout", 1)
+			return last, ErrWaitTimeout
+		}
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-waitCtx.Done():
+			timer.Stop()
+			return last, waitError(ctx)
+		}
+	}
+}
+
+func waitError(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		metrics.QueueOutcomes.Add("canceled", 1)
+		return err
+	}
+	metrics.QueueOutcomes.Add("timeout", 1)
+	return ErrWaitTimeout
+}
+
+func (rl *RateLimiter) delay(key string, capacity, rate int, algorithm AlgorithmType, cost int) time.Duration {
+	if rl.config.Shared != nil && time.Now().UnixNano() >= rl.fallbackUntil.Load() {
+		// Shared stores cannot predict refills; poll as fast as tokens
+		// arrive.
+		if rate <= 0 {
+			return never
+		}
+		return time.Second / time.Duration(rate)
+	}
+	return rl.getBucket(key, capacity, rate, algorithm).Delay(time.Now(), cost)
+}
+
+// peek describes a bucket without taking from it. Shared stores cannot be
+// read without consuming, so their remaining budget is reported as 0.
+func (rl *RateLimiter) peek(key string, capacity, rate int, algorithm AlgorithmType, cost int) Decision {
+	decision := Decision{Limit: capacity, Cost: cost}
+	if rl.config.Shared == nil || time.Now().UnixNano() < rl.fallbackUntil.Load() {
+		decision.Remaining = int(rl.getBucket(key, capacity, rate, algorithm).Inspect(time.Now()).Tokens)
+	}
+	return decision
+}
+
+func (rl *RateLimiter) wait(ctx context.Context, key string, capacity, rate int, algorithm AlgorithmType, cost int, config *ClientConfig) (Decision, error) {
+	maxWait := time.Duration(config.MaxWaitMs) * time.Millisecond
+	peek := func() Decision {
+		return rl.peek(key, capacity, rate, algorithm, cost)
+	}
+	return rl.queues.wait(ctx, key, config.QueueDepth, maxWait, peek, func() (Decision, time.Duration) {
+		decision := rl.allow(key, capacity, rate, algorithm, cost)
+		if decision.Allowed {
+			return decision, 0
+		}
+		return decision, rl.delay(key, capacity, rate, algorithm, cost)
+	})
+}
+
+// WaitWithConfig is AllowWithConfig for clients in wait mode: instead of
+// rejecting, the request queues until a token is available.
+func (rl *RateLimiter) WaitWithConfig(ctx context.Context, clientID string, config *ClientConfig, cost int) (Decision, error) {
+	return rl.wait(ctx, clientID, config.Capacity, config.RatePerSec, rl.algorithmFor(config), cost, config)
+}
+
+func (rl *RateLimiter) WaitRoute(ctx context.Context, clientID string, rule *RouteRule, config *ClientConfig, cost int) (Decision, error) {
+	algorithm := rule.Algorithm
+	if algorithm == "" {
+		algorithm = rl.algorithmFor(&ClientConfig{})
+	}
+	return rl.wait(ctx, routeBucketKey(clientID, rule.Name), rule.Capacity, rule.RatePerSec, algorithm, cost, config)
+}
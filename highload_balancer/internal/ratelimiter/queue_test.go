@@ -0,0 +1,50 @@
+package ratelimiter
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestQueueFullDecision(t *testing.T) {
+	rl := NewRateLimiter(100, 0, nil)
+	config := &ClientConfig{ClientID: "client", Capacity: 2, RatePerSec: 1, QueueDepth: 1, MaxWaitMs: 5000}
+
+	if d, err := rl.WaitWithConfig(context.Background(), "client", config, 2); err != nil || !d.Allowed {
+		t.Fatalf("first request = %+v, %v", d, err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		_, err := rl.WaitWithConfig(ctx, "client", config, 1)
+		done <- err
+	}()
+	// Let the second request take the only place in the queue.
+	for deadline := time.Now().Add(time.Second); ; time.Sleep(time.Millisecond) {
+		rl.queues.mux.Lock()
+		q := rl.queues.queues["client"]
+		queued := q != nil && q.waiting == 1
+		rl.queues.mux.Unlock()
+		if queued {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("second request did not queue")
+		}
+	}
+
+	d, err := rl.WaitWithConfig(context.Background(), "client", config, 1)
+	if !errors.Is(err, ErrQueueFull) {
+		t.Fatalf("third request error = %v, want ErrQueueFull", err)
+	}
+	if d.Allowed || d.Limit != 2 || d.Cost != 1 || d.Remaining != 0 {
+		t.Fatalf("queue full decision = %+v, want limit 2, cost 1, nothing remaining", d)
+	}
+
+	cancel()
+	if err := <-done; !errors.Is(err, context.Canceled) {
+		t.Fatalf("queued request error = %v, want context.Canceled", err)
+	}
+}
@@ -0,0 +1,275 @@
+package ratelimiter
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+)
+
+type QuotaPeriod string
+
+const (
+	DailyQuota   QuotaPeriod = "daily"
+	MonthlyQuota QuotaPeriod = "monthly"
+)
+
+type UsageRecord struct {
+	ClientID    string
+	Period      QuotaPeriod
+	PeriodStart time.Time
+	Count       int64
+}
+
+type UsageStorage interface {
+	LoadUsage(ctx context.Context, since time.Time) ([]UsageRecord, error)
+	AddUsage(ctx context.Context, records []UsageRecord) ([]UsageRecord, error)
+}
+
+type QuotaUsage struct {
+	Period      QuotaPeriod `json:"period"`
+	Limit       int64       `json:"limit"`
+	Used        int64       `json:"used"`
+	PeriodStart time.Time   `json:"period_start"`
+	ResetsAt    time.Time   `json:"resets_at"`
+}
+
+type usageKey struct {
+	clientID string
+	period   QuotaPeriod
+	start    int64
+}
+
+type usageCounter struct {
+	// count is the last total known from storage plus everything consumed
+	// here since; pending is the part not yet flushed.
+	count   int64
+	pending int64
+	end     time.Time
+}
+
+// QuotaTracker enforces long-window request quotas. Counters are kept in
+// memory and flushed to storage in batches, so a restart loses at most one
+// flush interval of usage. Without storage, usage is kept in memory only.
+type QuotaTracker struct {
+	storage       UsageStorage
+	location      *time.Location
+	flushInterval time.Duration
+	counters      map[usageKey]*usageCounter
+	mux           sync.Mutex
+	stopChan      chan struct{}
+	doneChan      chan struct{}
+}
+
+func NewQuotaTracker(storage UsageStorage, location *time.Location, flushInterval time.Duration) *QuotaTracker {
+	if location == nil {
+		location = time.UTC
+	}
+	if flushInterval <= 0 {
+		flushInterval = 5 * time.Second
+	}
+
+	qt := &QuotaTracker{
+		storage:       storage,
+		location:      location,
+		flushInterval: flushInterval,
+		counters:      make(map[usageKey]*usageCounter),
+		stopChan:      make(chan struct{}),
+		doneChan:      make(chan struct{}),
+	}
+	qt.load()
+	go qt.flushLoop()
+	return qt
+}
+
+func (qt *QuotaTracker) load() {
+	if qt.storage == nil {
+		return
+	}
+
+	// A month is the longest period, so anything older is already over.
+	records, err := qt.storage.LoadUsage(context.Background(), time.Now().AddDate(0, -1, -1))
+	if err != nil {
+		log.Printf("Failed to load quota usage: %v", err)
+		return
+	}
+
+	qt.mux.Lock()
+	defer qt.mux.Unlock()
+	for _, rec := range records {
+		start := rec.PeriodStart.In(qt.location)
+		qt.counters[usageKey{rec.ClientID, rec.Period, start.Unix()}] = &usageCounter{
+			count: rec.Count,
+			end:   periodEnd(rec.Period, start),
+		}
+	}
+}
+
+func (qt *QuotaTracker) Stop() {
+	close(qt.stopChan)
+	<-qt.doneChan
+}
+
+func quotaLimit(config *ClientConfig, period QuotaPeriod) int64 {
+	if period == DailyQuota {
+		return config.DailyQuota
+	}
+	return config.MonthlyQuota
+}
+
+func (qt *QuotaTracker) periodStart(period QuotaPeriod, config *ClientConfig, now time.Time) time.Time {
+	now = now.In(qt.location)
+	y, m, d := now.Date()
+
+	if period == DailyQuota {
+		return time.Date(y, m, d, 0, 0, 0, 0, qt.location)
+	}
+
+	day := min(max(config.QuotaResetDay, 1), 28)
+	start := time.Date(y, m, day, 0, 0, 0, 0, qt.location)
+	if now.Before(start) {
+		start = start.AddDate(0, -1, 0)
+	}
+	return start
+}
+
+func periodEnd(period QuotaPeriod, start time.Time) time.Time {
+	if period == DailyQuota {
+		return start.AddDate(0, 0, 1)
+	}
+	return start.AddDate(0, 1, 0)
+}
+
+func (qt *QuotaTracker) counter(clientID string, period QuotaPeriod, start time.Time) *usageCounter {
+	key := usageKey{clientID, period, start.Unix()}
+	c, exists := qt.counters[key]
+	if !exists {
+		c = &usageCounter{end: periodEnd(period, start)}
+		qt.counters[key] = c
+	}
+	return c
+}
+
+// Consume records one request against every quota configured for the
+// client. If any quota is exhausted nothing is recorded and the exhausted
+// quota is returned.
+func (qt *QuotaTracker) Consume(clientID string, config *ClientConfig, now time.Time) (QuotaUsage, bool) {
+	qt.mux.Lock()
+	defer qt.mux.Unlock()
+
+	var counters []*usageCounter
+	for _, period := range []QuotaPeriod{DailyQuota, MonthlyQuota} {
+		limit := quotaLimit(config, period)
+		if limit <= 0 {
+			continue
+		}
+
+		start := qt.periodStart(period, config, now)
+		c := qt.counter(clientID, period, start)
+		if c.count >= limit {
+			return QuotaUsage{
+				Period:      period,
+				Limit:       limit,
+				Used:        c.count,
+				PeriodStart: start,
+				ResetsAt:    c.end,
+			}, false
+		}
+		counters = append(counters, c)
+	}
+
+	for _, c := range counters {
+		c.count++
+		c.pending++
+	}
+	return QuotaUsage{}, true
+}
+
+func (qt *QuotaTracker) Usage(clientID string, config *ClientConfig, now time.Time) []QuotaUsage {
+	qt.mux.Lock()
+	defer qt.mux.Unlock()
+
+	usage := []QuotaUsage{}
+	for _, period := range []QuotaPeriod{DailyQuota, MonthlyQuota} {
+		start := qt.periodStart(period, config, now)
+		var used int64
+		if c, exists := qt.counters[usageKey{clientID, period, start.Unix()}]; exists {
+			used = c.count
+		}
+		usage = append(usage, QuotaUsage{
+			Period:      period,
+			Limit:       quotaLimit(config, period),
+			Used:        used,
+			PeriodStart: start,
+			ResetsAt:    periodEnd(period, start),
+		})
+	}
+	return usage
+}
+
+func (qt *QuotaTracker) flushLoop() {
+	defer close(qt.doneChan)
+
+	ticker := time.NewTicker(qt.flushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			qt.flush()
+		case <-qt.stopChan:
+			qt.flush()
+			return
+		}
+	}
+}
+
+func (qt *QuotaTracker) flush() {
+	now := time.Now()
+
+	qt.mux.Lock()
+	var batch []UsageRecord
+	for key, c := range qt.counters {
+		if c.pending == 0 {
+			if now.After(c.end) {
+				delete(qt.counters, key)
+			}
+			continue
+		}
+		batch = append(batch, UsageRecord{
+			ClientID:    key.clientID,
+			Period:      key.period,
+			PeriodStart: time.Unix(key.start, 0),
+			Count:       c.pending,
+		})
+		c.pending = 0
+	}
+	qt.mux.Unlock()
+
+	if len(batch) == 0 || qt.storage == nil {
+		return
+	}
+
+	totals, err := qt.storage.AddUsage(context.Background(), batch)
+	if err != nil {
+		log.Printf("Failed to flush quota usage: %v", err)
+		qt.mux.Lock()
+		for _, rec := range batch {
+			if c, exists := qt.counters[usageKey{rec.ClientID, rec.Period, rec.PeriodStart.Unix()}]; exists {
+				c.pending += rec.Count
+			}
+		}
+		qt.mux.Unlock()
+		return
+	}
+
+	// Totals include what other replicas flushed, so adopt them while
+	// keeping anything consumed here since the batch was taken.
+	qt.mux.Lock()
+	defer qt.mux.Unlock()
+	for _, rec := range totals {
+		if c, exists := qt.counters[usageKey{rec.ClientID, rec.Period, rec.PeriodStart.Unix()}]; exists {
+			c.count = rec.Count + c.pending
+		}
+	}
+}
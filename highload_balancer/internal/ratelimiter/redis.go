@@ -0,0 +1,140 @@
+package ratelimiter
+
+import (
+	"context"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// SharedStore keeps limiter state outside the process so that every
+// balancer replica enforces the same limit for a client.
+type SharedStore interface {
+	Allow(ctx context.Context, key string, algorithm AlgorithmType, capacity, rate, cost int) (bool, float64, error)
+	// Refund gives back cost taken by an earlier Allow with the same
+	// arguments.
+	Refund(ctx context.Context, key string, algorithm AlgorithmType, capacity, rate, cost int) error
+	Reset(ctx context.Context, key string) error
+}
+
+// Both scripts read the clock from Redis so replicas with skewed clocks
+// still agree on refill timing.
+var tokenBucketScript = redis.NewScript(`
+local capacity = tonumber(ARGV[1])
+local rate = tonumber(ARGV[2])
+local cost = tonumber(ARGV[3])
+local t = redis.call('TIME')
+local now = tonumber(t[1]) + tonumber(t[2]) / 1000000
+
+local state = redis.call('HMGET', KEYS[1], 'tokens', 'ts')
+local tokens = tonumber(state[1])
+local ts = tonumber(state[2])
+if tokens == nil or ts == nil then
+	tokens = capacity
+	ts = now
+end
+if now > ts then
+	tokens = tokens + (now - ts) * rate
+	ts = now
+end
+tokens = math.min(tokens, capacity)
+
+local allowed = 0
+if tokens >= cost then
+	tokens = tokens - cost
+	allowed = 1
+end
+
+redis.call('HSET', KEYS[1], 'tokens', tokens, 'ts', ts)
+if rate > 0 then
+	redis.call('EXPIRE', KEYS[1], math.ceil(capacity / rate) + 1)
+end
+return {allowed, math.floor(math.max(tokens, 0))}
+`)
+
+var gcraScript = redis.NewScript(`
+local capacity = tonumber(ARGV[1])
+local rate = tonumber(ARGV[2])
+local cost = tonumber(ARGV[3])
+local t = redis.call('TIME')
+local now = tonumber(t[1]) + tonumber(t[2]) / 1000000
+
+local emission = 1 / rate
+local tolerance = emission * capacity
+local tat = tonumber(redis.call('GET', KEYS[1]))
+if tat == nil or tat < now then
+	tat = now
+end
+local new_tat = tat + cost * emission
+if new_tat - now > tolerance then
+	return {0, math.floor(math.max(tolerance - (tat - now), 0) / emission)}
+end
+
+redis.call('SET', KEYS[1], tostring(new_tat), 'EX', math.ceil(tolerance) + 1)
+return {1, math.floor((tolerance - (new_tat - now)) / emission)}
+`)
+
+// The refund scripts leave missing keys alone: an expired bucket is full.
+var tokenBucketRefundScript = redis.NewScript(`
+local capacity = tonumber(ARGV[1])
+local cost = tonumber(ARGV[2])
+local tokens = tonumber(redis.call('HGET', KEYS[1], 'tokens'))
+if tokens == nil then
+	return 0
+end
+redis.call('HSET', KEYS[1], 'tokens', math.min(tokens + cost, capacity))
+return 1
+`)
+
+var gcraRefundScript = redis.NewScript(`
+local rate = tonumber(ARGV[1])
+local cost = tonumber(ARGV[2])
+local tat = tonumber(redis.call('GET', KEYS[1]))
+if tat == nil then
+	return 0
+end
+redis.call('SET', KEYS[1], tostring(tat - cost / rate), 'KEEPTTL')
+return 1
+`)
+
+type RedisStore struct {
+	client redis.UniversalClient
+	prefix string
+}
+
+func NewRedisStore(client redis.UniversalClient, prefix string) *RedisStore {
+	return &RedisStore{
+		client: client,
+		prefix: prefix,
+	}
+}
+
+// Allow runs GCRA for clients configured with it and a token bucket for
+// everything else; the sliding-window algorithms are enforced as a token
+// bucket with the same capacity and rate when state is shared.
+func (s *RedisStore) Allow(ctx context.Context, key string, algorithm AlgorithmType, capacity, rate, cost int) (bool, float64, error) {
+	script, stateKey := tokenBucketScript, s.prefix+"tb:"+key
+	if algorithm == GCRAAlgorithm && rate > 0 {
+		script, stateKey = gcraScript, s.prefix+"gcra:"+key
+	}
+
+	result, err := script.Run(ctx, s.client, []string{stateKey}, capacity, rate, cost).Int64Slice()
+	if err != nil {
+		return false, 0, err
+	}
+	return result[0] == 1, float64(result[1]), nil
+}
+
+func (s *RedisStore) Refund(ctx context.Context, key string, algorithm AlgorithmType, capacity, rate, cost int) error {
+	if algorithm == GCRAAlgorithm && rate > 0 {
+		return gcraRefundScript.Run(ctx, s.client, []string{s.prefix + "gcra:" + key}, rate, cost).Err()
+	}
+	return tokenBucketRefundScript.Run(ctx, s.client, []string{s.prefix + "tb:" + key}, capacity, cost).Err()
+}
+
+func (s *RedisStore) Reset(ctx context.Context, key string) error {
+	return s.client.Del(ctx, s.prefix+"tb:"+key, s.prefix+"gcra:"+key).Err()
+}
+
+func (s *RedisStore) Close() error {
+	return s.client.Close()
+}
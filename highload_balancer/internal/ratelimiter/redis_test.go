@@ -0,0 +1,186 @@
+package ratelimiter
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+// newTestRedisStore returns a store on an in-process Redis whose clock,
+// read by the scripts through TIME, is frozen at the returned time.
+func newTestRedisStore(t *testing.T) (*RedisStore, *miniredis.Miniredis, time.Time) {
+	t.Helper()
+	mr := miniredis.RunT(t)
+	now := time.Unix(1_700_000_000, 0)
+	mr.SetTime(now)
+
+	store := NewRedisStore(redis.NewClient(&redis.Options{Addr: mr.Addr()}), "test:")
+	t.Cleanup(func() { store.Close() })
+	return store, mr, now
+}
+
+func mustAllow(t *testing.T, store *RedisStore, algorithm AlgorithmType, capacity, rate, cost int) (bool, float64) {
+	t.Helper()
+	allowed, remaining, err := store.Allow(context.Background(), "client", algorithm, capacity, rate, cost)
+	if err != nil {
+		t.Fatalf("Allow: %v", err)
+	}
+	return allowed, remaining
+}
+
+func TestRedisRefill(t *testing.T) {
+	for _, algorithm := range []AlgorithmType{TokenBucketAlgorithm, GCRAAlgorithm} {
+		t.Run(string(algorithm), func(t *testing.T) {
+			store, mr, now := newTestRedisStore(t)
+
+			for i := 0; i < 5; i++ {
+				if allowed, _ := mustAllow(t, store, algorithm, 5, 1, 1); !allowed {
+					t.Fatalf("request %d rejected within capacity", i)
+				}
+			}
+			if allowed, _ := mustAllow(t, store, algorithm, 5, 1, 1); allowed {
+				t.Fatal("request admitted over capacity")
+			}
+
+			mr.SetTime(now.Add(2 * time.Second))
+			for i := 0; i < 2; i++ {
+				if allowed, _ := mustAllow(t, store, algorithm, 5, 1, 1); !allowed {
+					t.Fatalf("refilled request %d rejected", i)
+				}
+			}
+			if allowed, _ := mustAllow(t, store, algorithm, 5, 1, 1); allowed {
+				t.Fatal("admitted more than was refilled")
+			}
+		})
+	}
+}
+
+func TestRedisCost(t *testing.T) {
+	for _, algorithm := range []AlgorithmType{TokenBucketAlgorithm, GCRAAlgorithm} {
+		t.Run(string(algorithm), func(t *testing.T) {
+			store, _, _ := newTestRedisStore(t)
+
+			for _, want := range []float64{6, 2} {
+				allowed, remaining := mustAllow(t, store, algorithm, 10, 1, 4)
+				if !allowed || remaining != want {
+					t.Fatalf("Allow cost 4 = %v, %v; want true, %v", allowed, remaining, want)
+				}
+			}
+			// A rejected request costs nothing.
+			if allowed, remaining := mustAllow(t, store, algorithm, 10, 1, 4); allowed || remaining != 2 {
+				t.Fatalf("Allow cost 4 over budget = %v, %v; want false, 2", allowed, remaining)
+			}
+			if allowed, remaining := mustAllow(t, store, algorithm, 10, 1, 2); !allowed || remaining != 0 {
+				t.Fatalf("Allow of the remaining budget = %v, %v; want true, 0", allowed, remaining)
+			}
+		})
+	}
+
+	store, _, _ := newTestRedisStore(t)
+	if allowed, _ := mustAllow(t, store, TokenBucketAlgorithm, 10, 1, 11); allowed {
+		t.Fatal("request costing more than capacity admitted")
+	}
+}
+
+func TestRedisTTL(t *testing.T) {
+	for _, c := range []struct {
+		algorithm AlgorithmType
+		key       string
+	}{
+		{TokenBucketAlgorithm, "test:tb:client"},
+		{GCRAAlgorithm, "test:gcra:client"},
+	} {
+		t.Run(string(c.algorithm), func(t *testing.T) {
+			store, mr, now := newTestRedisStore(t)
+
+			mustAllow(t, store, c.algorithm, 10, 2, 10)
+			ttl := mr.TTL(c.key)
+			// The key must outlive a full refill, 5s, but not by much.
+			if ttl < 5*time.Second || ttl > 7*time.Second {
+				t.Fatalf("TTL = %v, want about 6s", ttl)
+			}
+
+			mr.FastForward(ttl)
+			mr.SetTime(now.Add(ttl))
+			if mr.Exists(c.key) {
+				t.Fatal("state outlived its TTL")
+			}
+			if allowed, remaining := mustAllow(t, store, c.algorithm, 10, 2, 1); !allowed || remaining != 9 {
+				t.Fatalf("Allow after expiry = %v, %v; want a full bucket", allowed, remaining)
+			}
+		})
+	}
+}
+
+func TestRedisConcurrent(t *testing.T) {
+	for _, algorithm := range []AlgorithmType{TokenBucketAlgorithm, GCRAAlgorithm} {
+		t.Run(string(algorithm), func(t *testing.T) {
+			store, _, _ := newTestRedisStore(t)
+
+			var (
+				admitted atomic.Int64
+				wg       sync.WaitGroup
+			)
+			for i := 0; i < 20; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					for j := 0; j < 10; j++ {
+						allowed, _, err := store.Allow(context.Background(), "client", algorithm, 50, 1, 1)
+						if err != nil {
+							t.Error(err)
+							return
+						}
+						if allowed {
+							admitted.Add(1)
+						}
+					}
+				}()
+			}
+			wg.Wait()
+
+			// The clock is frozen, so exactly the capacity is admitted.
+			if got := admitted.Load(); got != 50 {
+				t.Fatalf("admitted %d of 200 concurrent requests, want 50", got)
+			}
+		})
+	}
+}
+
+func TestRedisReset(t *testing.T) {
+	store, _, _ := newTestRedisStore(t)
+
+	mustAllow(t, store, TokenBucketAlgorithm, 3, 1, 3)
+	if err := store.Reset(context.Background(), "client"); err != nil {
+		t.Fatal(err)
+	}
+	if allowed, remaining := mustAllow(t, store, TokenBucketAlgorithm, 3, 1, 1); !allowed || remaining != 2 {
+		t.Fatalf("Allow after reset = %v, %v; want true, 2", allowed, remaining)
+	}
+}
+
+func TestRedisRefund(t *testing.T) {
+	for _, algorithm := range []AlgorithmType{TokenBucketAlgorithm, GCRAAlgorithm} {
+		t.Run(string(algorithm), func(t *testing.T) {
+			store, _, _ := newTestRedisStore(t)
+			ctx := context.Background()
+
+			// Refunding a bucket that does not exist leaves it full.
+			if err := store.Refund(ctx, "client", algorithm, 5, 1, 2); err != nil {
+				t.Fatal(err)
+			}
+			mustAllow(t, store, algorithm, 5, 1, 5)
+			if err := store.Refund(ctx, "client", algorithm, 5, 1, 2); err != nil {
+				t.Fatal(err)
+			}
+			if allowed, remaining := mustAllow(t, store, algorithm, 5, 1, 2); !allowed || remaining != 0 {
+				t.Fatalf("Allow of refunded tokens = %v, %v; want true, 0", allowed, remaining)
+			}
+		})
+	}
+}
@@ -0,0 +1,135 @@
+package ratelimiter
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// RouteRule gives requests matching all of its non-empty conditions a
+// bucket of their own, separate from the client's overall bucket. Cost is
+// how many tokens a matching request takes from both, 1 if unset.
+type RouteRule struct {
+	Name       string        `json:"name"`
+	Method     string        `json:"method,omitempty"`
+	Host       string        `json:"host,omitempty"`
+	PathPrefix string        `json:"path_prefix,omitempty"`
+	Capacity   int           `json:"capacity"`
+	RatePerSec int           `json:"rate_per_sec"`
+	Algorithm  AlgorithmType `json:"algorithm,omitempty"`
+	Cost       int           `json:"cost,omitempty"`
+}
+
+func (rr *RouteRule) Matches(method, host, path string) bool {
+	if rr.Method != "" && !strings.EqualFold(rr.Method, method) {
+		return false
+	}
+	if rr.Host != "" {
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		if !strings.EqualFold(rr.Host, host) {
+			return false
+		}
+	}
+	return strings.HasPrefix(path, rr.PathPrefix)
+}
+
+func ValidateRouteRules(rules []RouteRule) error {
+	seen := make(map[string]bool)
+	for _, rule := range rules {
+		if rule.Name == "" {
+			return fmt.Errorf("route rule name is required")
+		}
+		if seen[rule.Name] {
+			return fmt.Errorf("duplicate route rule %q", rule.Name)
+		}
+		seen[rule.Name] = true
+		if rule.Capacity < 0 || rule.RatePerSec < 0 || rule.Cost < 0 {
+			return fmt.Errorf("route rule %q: limits must not be negative", rule.Name)
+		}
+		if rule.Capacity == 0 {
+			return fmt.Errorf("route rule %q: capacity must be positive", rule.Name)
+		}
+		if !rule.Algorithm.Valid() {
+			return fmt.Errorf("route rule %q: unknown algorithm %q", rule.Name, rule.Algorithm)
+		}
+	}
+	return nil
+}
+
+func routeBucketKey(clientID, rule string) string {
+	return clientID + "#" + rule
+}
+
+// routesFor lists the rules in effect for a client: its own rules first,
+// then the defaults it does not override by name.
+func (rl *RateLimiter) routesFor(config *ClientConfig) []RouteRule {
+	if config == nil || len(config.Routes) == 0 {
+		return rl.config.Routes
+	}
+
+	rules := append([]RouteRule{}, config.Routes...)
+	for _, rule := range rl.config.Routes {
+		overridden := false
+		for _, own := range config.Routes {
+			if own.Name == rule.Name {
+				overridden = true
+				break
+			}
+		}
+		if !overridden {
+			rules = append(rules, rule)
+		}
+	}
+	return rules
+}
+
+// MatchRoute returns the first rule in effect for the client that matches
+// the request, or nil. config may be nil for unregistered clients.
+func (rl *RateLimiter) MatchRoute(config *ClientConfig, method, host, path string) *RouteRule {
+	for _, rule := range rl.routesFor(config) {
+		if rule.Matches(method, host, path) {
+			return &rule
+		}
+	}
+	return nil
+}
+
+func (rl *RateLimiter) AllowRoute(clientID string, rule *RouteRule, cost int) Decision {
+	algorithm := rule.Algorithm
+	if algorithm == "" {
+		algorithm = rl.algorithmFor(&ClientConfig{})
+	}
+	return rl.allow(routeBucketKey(clientID, rule.Name), rule.Capacity, rule.RatePerSec, algorithm, cost)
+}
+
+// RequestCost works out how many tokens a request takes: the matched
+// rule's cost plus any surcharge for the declared body size. A request
+// from a trusted upstream may raise that with the cost header, never
+// lower it.
+func (rl *RateLimiter) RequestCost(r *http.Request, rule *RouteRule, trusted bool) int {
+	cost := 1
+	if rule != nil && rule.Cost > 0 {
+		cost = rule.Cost
+	}
+	if rl.config.BodyCostBytes > 0 && r.ContentLength > 0 {
+		cost += int(r.ContentLength / rl.config.BodyCostBytes)
+	}
+	if trusted && rl.config.CostHeader != "" {
+		if declared, err := strconv.Atoi(r.Header.Get(rl.config.CostHeader)); err == nil && declared > cost {
+			cost = declared
+		}
+	}
+	return cost
+}
+
+// StripCostHeader removes the cost header from a request that did not
+// come from a trusted upstream, so the backend never sees a forged one.
+func (rl *RateLimiter) StripCostHeader(r *http.Request) {
+	if rl.config.CostHeader != "" {
+		r.Header.Del(rl.config.CostHeader)
+	}
+}
@@ -0,0 +1,134 @@
+package ratelimiter
+
+import (
+	"container/list"
+	"sync"
+	"time"
+
+	"github.com/se1y4/highload-balancer/internal/metrics"
+)
+
+// idleScanLimit bounds how many least-recently-used entries an insert
+// inspects for idle eviction, so a single insert stays O(1).
+const idleScanLimit = 8
+
+type bucketEntry struct {
+	key     string
+	limiter Limiter
+	// capacity and rate are the configured limits before any division
+	// across cluster peers.
+	capacity int
+	rate     int
+	lastSeen time.Time
+}
+
+type bucketShard struct {
+	entries  map[string]*list.Element
+	lru      *list.List
+	capacity int
+	idleTTL  time.Duration
+	mux      sync.Mutex
+}
+
+func newBucketShard(capacity int, idleTTL time.Duration) *bucketShard {
+	return &bucketShard{
+		entries:  make(map[string]*list.Element),
+		lru:      list.New(),
+		capacity: capacity,
+		idleTTL:  idleTTL,
+	}
+}
+
+// get returns the bucket for key, creating it if needed. check is run on
+// an existing bucket under the shard lock, e.g. to reshape it.
+func (s *bucketShard) get(key string, now time.Time, create func() *bucketEntry, check func(*bucketEntry)) Limiter {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	if elem, ok := s.entries[key]; ok {
+		entry := elem.Value.(*bucketEntry)
+		entry.lastSeen = now
+		s.lru.MoveToFront(elem)
+		check(entry)
+		return entry.limiter
+	}
+
+	s.evictIdle(now)
+	if s.capacity > 0 && s.lru.Len() >= s.capacity {
+		s.remove(s.lru.Back())
+		metrics.BucketEviction.Add("capacity", 1)
+	}
+
+	entry := create()
+	entry.key = key
+	entry.lastSeen = now
+	s.entries[key] = s.lru.PushFront(entry)
+	metrics.Buckets.Add(1)
+	return entry.limiter
+}
+
+// evictIdle drops least recently used buckets that have been idle longer
+// than the TTL and have fully recovered, since recreating them on the
+// next request yields the same state.
+func (s *bucketShard) evictIdle(now time.Time) {
+	if s.idleTTL <= 0 {
+		return
+	}
+
+	elem := s.lru.Back()
+	for i := 0; elem != nil && i < idleScanLimit; i++ {
+		entry := elem.Value.(*bucketEntry)
+		if now.Sub(entry.lastSeen) < s.idleTTL {
+			return
+		}
+		prev := elem.Prev()
+		if entry.limiter.Full(now) {
+			s.remove(elem)
+			metrics.BucketEviction.Add("idle", 1)
+		}
+		elem = prev
+	}
+}
+
+func (s *bucketShard) remove(elem *list.Element) {
+	entry := s.lru.Remove(elem).(*bucketEntry)
+	delete(s.entries, entry.key)
+	metrics.Buckets.Add(-1)
+}
+
+func (s *bucketShard) update(key string, apply func(*bucketEntry)) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	if elem, ok := s.entries[key]; ok {
+		apply(elem.Value.(*bucketEntry))
+	}
+}
+
+func (s *bucketShard) forEach(apply func(*bucketEntry)) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	for elem := s.lru.Front(); elem != nil; elem = elem.Next() {
+		apply(elem.Value.(*bucketEntry))
+	}
+}
+
+func (s *bucketShard) delete(key string) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	if elem, ok := s.entries[key]; ok {
+		s.remove(elem)
+	}
+}
+
+func shardIndex(key string, shards int) int {
+	// FNV-1a, inlined to avoid allocating a hash.Hash per request.
+	h := uint32(2166136261)
+	for i := 0; i < len(key); i++ {
+		h ^= uint32(key[i])
+		h *= 16777619
+	}
+	return int(h % uint32(shards))
+}
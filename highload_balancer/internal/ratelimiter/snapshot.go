@@ -0,0 +1,122 @@
+package ratelimiter
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+type clientSnapshot struct {
+	SavedAt   time.Time                `json:"saved_at"`
+	Clients   map[string]*ClientConfig `json:"clients"`
+	Plans     map[string]*Plan         `json:"plans,omitempty"`
+	Overrides []*Override              `json:"overrides,omitempty"`
+}
+
+// UseSnapshot keeps a copy of clients, plans and overrides at path,
+// rewritten on every successful resync. If they could not be loaded from
+// storage, they are loaded from the snapshot instead, so limits stay
+// enforced while storage is down. It must be called before StartOverrides
+// and StartSync.
+func (cm *ClientManager) UseSnapshot(path string) {
+	cm.snapshotPath = path
+	if path == "" {
+		return
+	}
+	if !cm.stale {
+		cm.saveSnapshot()
+		return
+	}
+
+	snapshot, err := readSnapshot(path)
+	if err != nil {
+		log.Printf("Failed to load client snapshot %s: %v", path, err)
+		return
+	}
+
+	cm.mux.Lock()
+	defer cm.mux.Unlock()
+	if snapshot.Plans != nil {
+		cm.plans = snapshot.Plans
+	}
+	cm.overrides = make(map[int64]*Override)
+	for _, o := range snapshot.Overrides {
+		if err := o.Validate(); err != nil {
+			continue
+		}
+		cm.overrides[o.ID] = o
+	}
+	cm.clients = snapshot.Clients
+	if cm.clients == nil {
+		cm.clients = make(map[string]*ClientConfig)
+	}
+	cm.effective = make(map[string]*ClientConfig, len(cm.clients))
+	for id, client := range cm.clients {
+		cm.effective[id] = cm.resolveLocked(client)
+	}
+	cm.patterns = newClientPatterns(cm.clients)
+	log.Printf("Loaded %d clients from snapshot %s saved at %s", len(cm.clients), path, snapshot.SavedAt.Format(time.RFC3339))
+}
+
+// Stale reports whether the clients in memory were not loaded from
+// storage and may be out of date.
+func (cm *ClientManager) Stale() bool {
+	cm.mux.RLock()
+	defer cm.mux.RUnlock()
+	return cm.stale
+}
+
+func readSnapshot(path string) (*clientSnapshot, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var snapshot clientSnapshot
+	if err := json.Unmarshal(data, &snapshot); err != nil {
+		return nil, err
+	}
+	return &snapshot, nil
+}
+
+func (cm *ClientManager) saveSnapshot() {
+	if cm.snapshotPath == "" {
+		return
+	}
+
+	cm.mux.RLock()
+	snapshot := clientSnapshot{
+		SavedAt: time.Now(),
+		Clients: cm.clients,
+		Plans:   cm.plans,
+	}
+	for _, o := range cm.overrides {
+		snapshot.Overrides = append(snapshot.Overrides, o)
+	}
+	data, err := json.MarshalIndent(snapshot, "", "  ")
+	cm.mux.RUnlock()
+	if err != nil {
+		log.Printf("Failed to encode client snapshot: %v", err)
+		return
+	}
+
+	// Written next to the target and renamed, so a crash never leaves a
+	// truncated snapshot behind.
+	tmp, err := os.CreateTemp(filepath.Dir(cm.snapshotPath), filepath.Base(cm.snapshotPath)+".*")
+	if err != nil {
+		log.Printf("Failed to write client snapshot: %v", err)
+		return
+	}
+	_, err = tmp.Write(data)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), cm.snapshotPath)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		log.Printf("Failed to write client snapshot: %v", err)
+	}
+}
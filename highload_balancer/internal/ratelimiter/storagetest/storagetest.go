@@ -0,0 +1,319 @@
+// Package storagetest checks that a ClientStorage behaves like
+// PostgresStorage, so that every storage driver can be held to the same
+// semantics. A driver's tests call Run with a constructor for fresh
+// storages.
+package storagetest
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/se1y4/highload-balancer/internal/ratelimiter"
+)
+
+// Opener returns a new storage holding exactly clients. Writable storages
+// may be seeded through SaveClient; read-only ones must be loaded with
+// the clients some other way.
+type Opener func(t *testing.T, clients []*ratelimiter.ClientConfig) ratelimiter.ClientStorage
+
+// Run checks storage semantics. Read-only storages, whose writes fail with
+// ratelimiter.ErrReadOnlyStorage, are checked for reads only. Plans,
+// overrides and ACL entries are checked when the storage supports them.
+func Run(t *testing.T, open Opener) {
+	t.Run("Read", func(t *testing.T) {
+		seed := seedClients()
+		s := openStorage(t, open, seed)
+
+		all, err := s.GetAllClients(ctx)
+		if err != nil {
+			t.Fatalf("GetAllClients: %v", err)
+		}
+		if len(all) != len(seed) {
+			t.Fatalf("GetAllClients returned %d clients, want %d", len(all), len(seed))
+		}
+		for _, want := range seed {
+			checkClient(t, all[want.ClientID], want)
+
+			got, err := s.GetClient(ctx, want.ClientID)
+			if err != nil {
+				t.Fatalf("GetClient(%q): %v", want.ClientID, err)
+			}
+			checkClient(t, got, want)
+		}
+
+		got, err := s.GetClient(ctx, "missing")
+		if err != nil || got != nil {
+			t.Fatalf("GetClient of a missing client = %v, %v; want nil, nil", got, err)
+		}
+	})
+
+	s := openStorage(t, open, nil)
+	if err := s.SaveClient(ctx, newClient("probe")); errors.Is(err, ratelimiter.ErrReadOnlyStorage) {
+		t.Run("ReadOnly", func(t *testing.T) {
+			testReadOnly(t, open)
+		})
+		return
+	} else if err != nil {
+		t.Fatalf("SaveClient: %v", err)
+	}
+
+	t.Run("Write", func(t *testing.T) {
+		testWrite(t, openStorage(t, open, nil))
+	})
+	if _, ok := s.(ratelimiter.PlanStorage); ok {
+		t.Run("Plans", func(t *testing.T) {
+			testPlans(t, openStorage(t, open, nil).(ratelimiter.PlanStorage))
+		})
+	}
+	if _, ok := s.(ratelimiter.OverrideStorage); ok {
+		t.Run("Overrides", func(t *testing.T) {
+			testOverrides(t, openStorage(t, open, nil))
+		})
+	}
+	if _, ok := s.(ratelimiter.ACLStorage); ok {
+		t.Run("ACL", func(t *testing.T) {
+			testACL(t, openStorage(t, open, nil).(ratelimiter.ACLStorage))
+		})
+	}
+}
+
+func openStorage(t *testing.T, open Opener, clients []*ratelimiter.ClientConfig) ratelimiter.ClientStorage {
+	t.Helper()
+	s := open(t, clients)
+	t.Cleanup(func() {
+		if err := s.Close(); err != nil {
+			t.Errorf("Close: %v", err)
+		}
+	})
+	return s
+}
+
+var ctx = context.Background()
+
+// now is truncated to what PostgreSQL stores.
+func now() time.Time {
+	return time.Now().Truncate(time.Microsecond)
+}
+
+func newClient(id string) *ratelimiter.ClientConfig {
+	t := now()
+	return &ratelimiter.ClientConfig{
+		ClientID:    id,
+		Capacity:    10,
+		RatePerSec:  1,
+		CreatedAt:   t,
+		LastUpdated: t,
+	}
+}
+
+func seedClients() []*ratelimiter.ClientConfig {
+	full := newClient("192.0.2.1")
+	full.Algorithm = ratelimiter.GCRAAlgorithm
+	full.Plan = "pro"
+	full.DailyQuota = 1000
+	full.MonthlyQuota = 20000
+	full.QuotaResetDay = 15
+	full.Routes = []ratelimiter.RouteRule{{
+		Name:       "reports",
+		Method:     "POST",
+		PathPrefix: "/reports",
+		Capacity:   5,
+		RatePerSec: 1,
+		Cost:       2,
+	}}
+	full.MaxConcurrent = 4
+	full.QueueDepth = 8
+	full.MaxWaitMs = 250
+	full.Priority = 2
+	full.CreatedAt = full.CreatedAt.Add(-time.Hour)
+
+	subnet := newClient("10.0.0.0/24")
+	subnet.SharedBucket = true
+
+	return []*ratelimiter.ClientConfig{full, subnet, newClient("api-key")}
+}
+
+// normalize drops differences that do not matter: time zones and nil
+// versus empty routes.
+func normalize(c ratelimiter.ClientConfig) ratelimiter.ClientConfig {
+	c.CreatedAt = c.CreatedAt.UTC()
+	c.LastUpdated = c.LastUpdated.UTC()
+	if len(c.Routes) == 0 {
+		c.Routes = nil
+	}
+	return c
+}
+
+func checkClient(t *testing.T, got, want *ratelimiter.ClientConfig) {
+	t.Helper()
+	if got == nil {
+		t.Fatalf("client %q not found", want.ClientID)
+	}
+	if g, w := normalize(*got), normalize(*want); !reflect.DeepEqual(g, w) {
+		t.Fatalf("client %q:\n got %+v\nwant %+v", want.ClientID, g, w)
+	}
+}
+
+func testReadOnly(t *testing.T, open Opener) {
+	seed := seedClients()
+	s := openStorage(t, open, seed)
+
+	if err := s.SaveClient(ctx, seed[0]); !errors.Is(err, ratelimiter.ErrReadOnlyStorage) {
+		t.Fatalf("SaveClient = %v, want ErrReadOnlyStorage", err)
+	}
+	if err := s.DeleteClient(ctx, seed[0].ClientID); !errors.Is(err, ratelimiter.ErrReadOnlyStorage) {
+		t.Fatalf("DeleteClient = %v, want ErrReadOnlyStorage", err)
+	}
+	all, err := s.GetAllClients(ctx)
+	if err != nil || len(all) != len(seed) {
+		t.Fatalf("GetAllClients after rejected writes = %d clients, %v", len(all), err)
+	}
+}
+
+func testWrite(t *testing.T, s ratelimiter.ClientStorage) {
+	client := newClient("client")
+	if err := s.SaveClient(ctx, client); err != nil {
+		t.Fatalf("SaveClient: %v", err)
+	}
+	got, err := s.GetClient(ctx, client.ClientID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkClient(t, got, client)
+
+	// Saving again updates everything but the creation time.
+	updated := *client
+	updated.Capacity = 50
+	updated.Routes = []ratelimiter.RouteRule{{Name: "search", PathPrefix: "/search", Capacity: 3, RatePerSec: 1}}
+	updated.CreatedAt = client.CreatedAt.Add(time.Hour)
+	updated.LastUpdated = client.LastUpdated.Add(time.Minute)
+	if err := s.SaveClient(ctx, &updated); err != nil {
+		t.Fatalf("SaveClient update: %v", err)
+	}
+	got, err = s.GetClient(ctx, client.ClientID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	updated.CreatedAt = client.CreatedAt
+	checkClient(t, got, &updated)
+
+	all, err := s.GetAllClients(ctx)
+	if err != nil || len(all) != 1 {
+		t.Fatalf("GetAllClients after update = %d clients, %v; want 1", len(all), err)
+	}
+
+	if err := s.DeleteClient(ctx, client.ClientID); err != nil {
+		t.Fatalf("DeleteClient: %v", err)
+	}
+	if got, err := s.GetClient(ctx, client.ClientID); err != nil || got != nil {
+		t.Fatalf("GetClient after delete = %v, %v; want nil, nil", got, err)
+	}
+	if err := s.DeleteClient(ctx, client.ClientID); err != nil {
+		t.Fatalf("DeleteClient of a missing client: %v", err)
+	}
+}
+
+func testPlans(t *testing.T, s ratelimiter.PlanStorage) {
+	plan := &ratelimiter.Plan{Name: "pro", Capacity: 100, RatePerSec: 10, DailyQuota: 5000}
+	if err := s.SavePlan(ctx, plan); err != nil {
+		t.Fatalf("SavePlan: %v", err)
+	}
+	plans, err := s.GetAllPlans(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	first := plans["pro"]
+	if first == nil || first.Capacity != 100 || first.DailyQuota != 5000 || first.CreatedAt.IsZero() {
+		t.Fatalf("GetAllPlans = %+v", first)
+	}
+
+	plan.Capacity = 200
+	if err := s.SavePlan(ctx, plan); err != nil {
+		t.Fatalf("SavePlan update: %v", err)
+	}
+	plans, err = s.GetAllPlans(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := plans["pro"]; got == nil || got.Capacity != 200 || !got.CreatedAt.Equal(first.CreatedAt) {
+		t.Fatalf("plan after update = %+v, want capacity 200 created at %v", got, first.CreatedAt)
+	}
+
+	if err := s.DeletePlan(ctx, "pro"); err != nil {
+		t.Fatalf("DeletePlan: %v", err)
+	}
+	if plans, err = s.GetAllPlans(ctx); err != nil || len(plans) != 0 {
+		t.Fatalf("GetAllPlans after delete = %v, %v", plans, err)
+	}
+}
+
+func testOverrides(t *testing.T, s ratelimiter.ClientStorage) {
+	overrides := s.(ratelimiter.OverrideStorage)
+	for _, id := range []string{"a", "b"} {
+		if err := s.SaveClient(ctx, newClient(id)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ends := now().Add(time.Hour)
+	var ids []int64
+	for _, clientID := range []string{"a", "a", "b"} {
+		o := &ratelimiter.Override{ClientID: clientID, Capacity: 5, EndsAt: &ends, CreatedAt: now()}
+		if err := overrides.SaveOverride(ctx, o); err != nil {
+			t.Fatalf("SaveOverride: %v", err)
+		}
+		ids = append(ids, o.ID)
+	}
+	if ids[0] == ids[1] || ids[1] == ids[2] || ids[0] == ids[2] {
+		t.Fatalf("SaveOverride assigned IDs %v, want distinct", ids)
+	}
+
+	if err := overrides.DeleteOverride(ctx, ids[0]); err != nil {
+		t.Fatalf("DeleteOverride: %v", err)
+	}
+	// Deleting a client deletes its overrides.
+	if err := s.DeleteClient(ctx, "b"); err != nil {
+		t.Fatal(err)
+	}
+
+	all, err := overrides.GetAllOverrides(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(all) != 1 || all[0].ID != ids[1] || all[0].EndsAt == nil || !all[0].EndsAt.Equal(ends) {
+		t.Fatalf("GetAllOverrides = %+v, want only override %d", all, ids[1])
+	}
+}
+
+func testACL(t *testing.T, s ratelimiter.ACLStorage) {
+	past := now().Add(-time.Minute)
+	entries := []*ratelimiter.ACLEntry{
+		{CIDR: "10.0.0.0/8", Action: ratelimiter.ACLDeny, CreatedAt: now()},
+		{CIDR: "192.0.2.0/24", Action: ratelimiter.ACLAllow, CreatedAt: now()},
+		{CIDR: "198.51.100.0/24", Action: ratelimiter.ACLDeny, ExpiresAt: &past, CreatedAt: now()},
+	}
+	for _, entry := range entries {
+		if err := s.SaveACLEntry(ctx, entry); err != nil {
+			t.Fatalf("SaveACLEntry: %v", err)
+		}
+	}
+	// Saving the same prefix replaces the entry.
+	entries[1].Action = ratelimiter.ACLDeny
+	if err := s.SaveACLEntry(ctx, entries[1]); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.DeleteACLEntry(ctx, "10.0.0.0/8"); err != nil {
+		t.Fatalf("DeleteACLEntry: %v", err)
+	}
+
+	got, err := s.GetACLEntries(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 || got[0].CIDR != "192.0.2.0/24" || got[0].Action != ratelimiter.ACLDeny {
+		t.Fatalf("GetACLEntries = %+v, want only 192.0.2.0/24 denied", got)
+	}
+}
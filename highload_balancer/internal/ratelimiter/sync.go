@@ -0,0 +1,222 @@
+package ratelimiter
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+// ClientWatcher is implemented by storages that can push client changes
+// made by other instances.
+type ClientWatcher interface {
+	WatchClients(onChange func(clientID string), onResync func()) (func(), error)
+}
+
+// StartSync keeps the in-memory clients in line with storage: changes
+// are applied as the storage reports them, and everything is reloaded
+// every resyncInterval in case a notification was lost.
+func (cm *ClientManager) StartSync(resyncInterval time.Duration) {
+	if resyncInterval <= 0 {
+		resyncInterval = time.Minute
+	}
+
+	var stopWatch func()
+	if watcher, ok := cm.storage.(ClientWatcher); ok {
+		var err error
+		stopWatch, err = watcher.WatchClients(cm.reloadClient, cm.resync)
+		if err != nil {
+			log.Printf("Failed to watch client changes, relying on periodic resync: %v", err)
+		}
+	}
+
+	cm.wg.Add(1)
+	go func() {
+		defer cm.wg.Done()
+		if stopWatch != nil {
+			defer stopWatch()
+		}
+
+		ticker := time.NewTicker(resyncInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				cm.resync()
+			case <-cm.stopChan:
+				return
+			}
+		}
+	}()
+}
+
+// reloadClient fetches one client from storage and applies it, or drops
+// it if it is gone.
+func (cm *ClientManager) reloadClient(clientID string) {
+	client, err := cm.storage.GetClient(context.Background(), clientID)
+	if err != nil {
+		log.Printf("Failed to reload client %s: %v", clientID, err)
+		return
+	}
+	if client == nil {
+		cm.dropClient(clientID)
+		return
+	}
+	cm.applyClient(client)
+}
+
+// UseACL has every resync reload acl as well. It must be called before
+// StartSync.
+func (cm *ClientManager) UseACL(acl *ACL) {
+	cm.acl = acl
+}
+
+func (cm *ClientManager) resync() {
+	if err := cm.Resync(context.Background()); err != nil {
+		log.Printf("Failed to resync clients: %v", err)
+	}
+}
+
+// Resync reloads clients, plans, overrides and the ACL from storage, so
+// edits whose notification was lost still arrive, then refreshes the
+// snapshot.
+func (cm *ClientManager) Resync(ctx context.Context) error {
+	if err := cm.reloadPlansAndOverrides(ctx); err != nil {
+		return err
+	}
+	if cm.acl != nil {
+		if err := cm.acl.Prune(ctx); err != nil {
+			log.Printf("Failed to delete expired ACL entries: %v", err)
+		}
+		if err := cm.acl.Reload(ctx); err != nil {
+			return err
+		}
+	}
+
+	clients, err := cm.storage.GetAllClients(ctx)
+	if err != nil {
+		return err
+	}
+
+	cm.mux.RLock()
+	var removed []string
+	for id := range cm.clients {
+		if _, exists := clients[id]; !exists {
+			removed = append(removed, id)
+		}
+	}
+	cm.mux.RUnlock()
+
+	for _, id := range removed {
+		cm.dropClient(id)
+	}
+	for _, client := range clients {
+		cm.applyClient(client)
+	}
+
+	cm.mux.Lock()
+	if cm.stale {
+		log.Printf("Clients are in sync with storage again")
+	}
+	cm.stale = false
+	cm.mux.Unlock()
+	cm.saveSnapshot()
+	return nil
+}
+
+func (cm *ClientManager) reloadPlansAndOverrides(ctx context.Context) error {
+	var plans map[string]*Plan
+	if cm.planStorage != nil {
+		var err error
+		if plans, err = cm.planStorage.GetAllPlans(ctx); err != nil {
+			return err
+		}
+	}
+	var overrides []*Override
+	if cm.overrideStorage != nil {
+		var err error
+		if overrides, err = cm.overrideStorage.GetAllOverrides(ctx); err != nil {
+			return err
+		}
+	}
+
+	cm.mux.Lock()
+	if plans != nil {
+		cm.plans = plans
+	}
+	cm.overrides = make(map[int64]*Override, len(overrides))
+	for _, o := range overrides {
+		if err := o.Validate(); err != nil {
+			log.Printf("Skipping invalid override %d: %v", o.ID, err)
+			continue
+		}
+		cm.overrides[o.ID] = o
+	}
+	for id := range cm.active {
+		if _, exists := cm.overrides[id]; !exists {
+			delete(cm.active, id)
+		}
+	}
+	ids := make(map[string]bool, len(cm.clients))
+	for id := range cm.clients {
+		ids[id] = true
+	}
+	updates := cm.refreshLocked(ids)
+	cm.mux.Unlock()
+
+	if cm.rateLimiter != nil {
+		for _, config := range updates {
+			cm.rateLimiter.UpdateBucket(config.ClientID, config)
+		}
+	}
+	cm.applyOverrides()
+	return nil
+}
+
+// applyClient installs a client read from storage, skipping it if this
+// instance already has that version.
+func (cm *ClientManager) applyClient(client *ClientConfig) {
+	cm.mux.Lock()
+	existing, known := cm.clients[client.ClientID]
+	if known && existing.LastUpdated.Equal(client.LastUpdated) {
+		cm.mux.Unlock()
+		return
+	}
+	cm.clients[client.ClientID] = client
+	effective := cm.resolveLocked(client)
+	cm.effective[client.ClientID] = effective
+	if !known {
+		cm.patterns = newClientPatterns(cm.clients)
+	}
+	cm.mux.Unlock()
+
+	if cm.rateLimiter != nil {
+		if !known {
+			cm.rateLimiter.RemoveBucket(client.ClientID)
+		}
+		cm.rateLimiter.UpdateBucket(client.ClientID, effective)
+	}
+}
+
+// dropClient forgets a client deleted from storage, along with its
+// overrides, which storage deletes with it.
+func (cm *ClientManager) dropClient(clientID string) {
+	cm.mux.Lock()
+	if _, exists := cm.clients[clientID]; !exists {
+		cm.mux.Unlock()
+		return
+	}
+	delete(cm.clients, clientID)
+	delete(cm.effective, clientID)
+	cm.patterns = newClientPatterns(cm.clients)
+	for id, o := range cm.overrides {
+		if o.ClientID == clientID {
+			delete(cm.overrides, id)
+			delete(cm.active, id)
+		}
+	}
+	cm.mux.Unlock()
+
+	if cm.rateLimiter != nil {
+		cm.rateLimiter.RemoveBucket(clientID)
+	}
+}
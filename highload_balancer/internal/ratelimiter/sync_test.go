@@ -0,0 +1,54 @@
+package ratelimiter
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// TestResyncReloadsEverything checks that edits made by another instance,
+// whose notifications never arrived, are picked up by a resync.
+func TestResyncReloadsEverything(t *testing.T) {
+	storage, err := NewBoltStorage(filepath.Join(t.TempDir(), "clients.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer storage.Close()
+
+	ctx := context.Background()
+	if err := storage.SavePlan(ctx, &Plan{Name: "pro", Capacity: 10, RatePerSec: 1}); err != nil {
+		t.Fatal(err)
+	}
+	if err := storage.SaveClient(ctx, &ClientConfig{ClientID: "client", Plan: "pro"}); err != nil {
+		t.Fatal(err)
+	}
+
+	cm := NewClientManager(storage, nil)
+	acl := NewACL(storage)
+	cm.UseACL(acl)
+
+	if err := storage.SavePlan(ctx, &Plan{Name: "pro", Capacity: 50, RatePerSec: 5}); err != nil {
+		t.Fatal(err)
+	}
+	starts, ends := time.Now().Add(-time.Minute), time.Now().Add(time.Hour)
+	if err := storage.SaveOverride(ctx, &Override{ClientID: "client", Capacity: 200, StartsAt: &starts, EndsAt: &ends}); err != nil {
+		t.Fatal(err)
+	}
+	if err := storage.SaveACLEntry(ctx, &ACLEntry{CIDR: "192.0.2.0/24", Action: ACLDeny}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := cm.Resync(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if plan, _ := cm.GetPlan("pro"); plan.Capacity != 50 {
+		t.Errorf("plan capacity %d after resync, want 50", plan.Capacity)
+	}
+	if config, _ := cm.GetClientConfig("client"); config.Capacity != 200 || config.RatePerSec != 5 {
+		t.Errorf("client limits %d, %d after resync, want the override's 200 and the plan's 5", config.Capacity, config.RatePerSec)
+	}
+	if action := acl.Lookup("192.0.2.1"); action != ACLDeny {
+		t.Errorf("ACL action %q after resync, want deny", action)
+	}
+}
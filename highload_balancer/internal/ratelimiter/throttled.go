@@ -0,0 +1,89 @@
+package ratelimiter
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+const throttleSlots = 60
+
+type ThrottledClient struct {
+	ClientID   string `json:"client_id"`
+	Rejections int64  `json:"rejections"`
+}
+
+type throttleSlot struct {
+	start  time.Time
+	counts map[string]int64
+}
+
+// ThrottleStats counts rejections per client over a sliding window split
+// into fixed slots, so old counts expire a slot at a time.
+type ThrottleStats struct {
+	window time.Duration
+	width  time.Duration
+	slots  [throttleSlots]throttleSlot
+	mux    sync.Mutex
+}
+
+func NewThrottleStats(window time.Duration) *ThrottleStats {
+	if window <= 0 {
+		window = 5 * time.Minute
+	}
+	return &ThrottleStats{
+		window: window,
+		width:  max(window/throttleSlots, time.Millisecond),
+	}
+}
+
+func (ts *ThrottleStats) Window() time.Duration {
+	return ts.window
+}
+
+func (ts *ThrottleStats) Record(clientID string, now time.Time) {
+	start := now.Truncate(ts.width)
+	slot := &ts.slots[(start.UnixNano()/int64(ts.width))%throttleSlots]
+
+	ts.mux.Lock()
+	defer ts.mux.Unlock()
+
+	if !slot.start.Equal(start) {
+		slot.start = start
+		slot.counts = make(map[string]int64)
+	}
+	slot.counts[clientID]++
+}
+
+// Top returns up to n clients with the most rejections in the window.
+func (ts *ThrottleStats) Top(n int, now time.Time) []ThrottledClient {
+	since := now.Add(-ts.window)
+	totals := make(map[string]int64)
+
+	ts.mux.Lock()
+	for i := range ts.slots {
+		slot := &ts.slots[i]
+		if slot.counts == nil || !slot.start.After(since) {
+			continue
+		}
+		for clientID, count := range slot.counts {
+			totals[clientID] += count
+		}
+	}
+	ts.mux.Unlock()
+
+	top := make([]ThrottledClient, 0, len(totals))
+	for clientID, count := range totals {
+		top = append(top, ThrottledClient{ClientID: clientID, Rejections: count})
+	}
+	sort.Slice(top, func(i, j int) bool {
+		if top[i].Rejections != top[j].Rejections {
+			return top[i].Rejections > top[j].Rejections
+		}
+		return top[i].ClientID < top[j].ClientID
+	})
+	if n > 0 && len(top) > n {
+		top = top[:n]
+	}
+	return top
+}
@@ -0,0 +1,104 @@
+package ratelimiter
+
+import "net/netip"
+
+// prefixTrie maps IP prefixes to values and finds the prefixes covering
+// an address, one bit per level. IPv4 and IPv6 live in separate trees;
+// IPv4-mapped IPv6 addresses are looked up as IPv4.
+type prefixTrie[T any] struct {
+	v4 *trieNode[T]
+	v6 *trieNode[T]
+}
+
+type trieNode[T any] struct {
+	children [2]*trieNode[T]
+	value    T
+	set      bool
+}
+
+func newPrefixTrie[T any]() *prefixTrie[T] {
+	return &prefixTrie[T]{
+		v4: &trieNode[T]{},
+		v6: &trieNode[T]{},
+	}
+}
+
+func (t *prefixTrie[T]) root(addr netip.Addr) *trieNode[T] {
+	if addr.Is4() {
+		return t.v4
+	}
+	return t.v6
+}
+
+func (t *prefixTrie[T]) insert(prefix netip.Prefix, value T) {
+	prefix = normalizePrefix(prefix)
+	addr := prefix.Addr()
+	bits := addr.AsSlice()
+
+	node := t.root(addr)
+	for i := 0; i < prefix.Bits(); i++ {
+		b := bitAt(bits, i)
+		if node.children[b] == nil {
+			node.children[b] = &trieNode[T]{}
+		}
+		node = node.children[b]
+	}
+	node.value = value
+	node.set = true
+}
+
+// lookup calls match for every prefix containing addr, from the longest
+// down, until match returns true.
+func (t *prefixTrie[T]) lookup(addr netip.Addr, match func(T) bool) {
+	if !addr.IsValid() {
+		return
+	}
+	addr = addr.Unmap()
+	bits := addr.AsSlice()
+
+	var path []*trieNode[T]
+	node := t.root(addr)
+	for i := 0; node != nil; i++ {
+		if node.set {
+			path = append(path, node)
+		}
+		if i == addr.BitLen() {
+			break
+		}
+		node = node.children[bitAt(bits, i)]
+	}
+
+	for i := len(path) - 1; i >= 0; i-- {
+		if match(path[i].value) {
+			return
+		}
+	}
+}
+
+func bitAt(b []byte, i int) int {
+	return int(b[i/8]>>(7-i%8)) & 1
+}
+
+// normalizePrefix masks host bits and turns IPv4-mapped IPv6 prefixes
+// into IPv4 ones, so equal networks get equal keys.
+func normalizePrefix(prefix netip.Prefix) netip.Prefix {
+	addr, bits := prefix.Addr(), prefix.Bits()
+	if addr.Is4In6() && bits >= 96 {
+		prefix = netip.PrefixFrom(addr.Unmap(), bits-96)
+	}
+	return prefix.Masked()
+}
+
+// parsePrefix accepts a CIDR or a bare address, which is taken as a
+// single-host prefix.
+func parsePrefix(s string) (netip.Prefix, error) {
+	if prefix, err := netip.ParsePrefix(s); err == nil {
+		return normalizePrefix(prefix), nil
+	}
+	addr, err := netip.ParseAddr(s)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	addr = addr.Unmap()
+	return netip.PrefixFrom(addr, addr.BitLen()), nil
+}
@@ -1,13 +1,107 @@
 package ratelimiter
 
-import "time"
+import (
+	"errors"
+	"fmt"
+	"time"
+)
 
+type AlgorithmType string
+
+const (
+	TokenBucketAlgorithm          AlgorithmType = "token-bucket"
+	SlidingWindowCounterAlgorithm AlgorithmType = "sliding-window-counter"
+	SlidingWindowLogAlgorithm     AlgorithmType = "sliding-window-log"
+	GCRAAlgorithm                 AlgorithmType = "gcra"
+)
+
+func (a AlgorithmType) Valid() bool {
+	switch a {
+	case "", TokenBucketAlgorithm, SlidingWindowCounterAlgorithm, SlidingWindowLogAlgorithm, GCRAAlgorithm:
+		return true
+	}
+	return false
+}
+
+type Config struct {
+	Algorithm  AlgorithmType
+	MaxBuckets int
+	IdleTTL    time.Duration
+	Shards     int
+	Routes     []RouteRule
+
+	// CostHeader names a request header, honoured only from trusted
+	// upstreams, that raises the cost of a request. BodyCostBytes, if
+	// positive, adds a token per that many bytes of declared request body.
+	CostHeader    string
+	BodyCostBytes int64
+
+	// Shared, when set, holds the authoritative state. Local buckets are
+	// only used while it is failing, retried every FallbackRetry.
+	Shared        SharedStore
+	SharedTimeout time.Duration
+	FallbackRetry time.Duration
+}
+
+// ClientConfig limits left at zero are taken from Plan when one is set.
+// Routes replace default route rules of the same name. MaxConcurrent of 0
+// leaves in-flight requests unlimited. With QueueDepth and MaxWaitMs set,
+// requests over the limit wait in line instead of being rejected.
+// Under overload, clients with a lower Priority are shed first.
+// ClientID may be a CIDR or glob; each matched address then gets its own
+// buckets unless SharedBucket is set.
+// Quotas of 0 are unlimited; monthly quotas reset on QuotaResetDay (1-28,
+// default 1) of each month.
 type ClientConfig struct {
-	ClientID    string    `json:"client_id"`
-	Capacity    int       `json:"capacity"`
-	RatePerSec  int       `json:"rate_per_sec"`
-	CreatedAt   time.Time `json:"created_at"`
-	LastUpdated time.Time `json:"last_updated"`
+	ClientID      string        `json:"client_id"`
+	Capacity      int           `json:"capacity"`
+	RatePerSec    int           `json:"rate_per_sec"`
+	Algorithm     AlgorithmType `json:"algorithm,omitempty"`
+	Plan          string        `json:"plan,omitempty"`
+	DailyQuota    int64         `json:"daily_quota,omitempty"`
+	MonthlyQuota  int64         `json:"monthly_quota,omitempty"`
+	QuotaResetDay int           `json:"quota_reset_day,omitempty"`
+	Routes        []RouteRule   `json:"routes,omitempty"`
+	MaxConcurrent int           `json:"max_concurrent,omitempty"`
+	QueueDepth    int           `json:"queue_depth,omitempty"`
+	MaxWaitMs     int           `json:"max_wait_ms,omitempty"`
+	Priority      int           `json:"priority,omitempty"`
+	SharedBucket  bool          `json:"shared_bucket,omitempty"`
+	CreatedAt     time.Time     `json:"created_at"`
+	LastUpdated   time.Time     `json:"last_updated"`
+}
+
+func (c *ClientConfig) WaitEnabled() bool {
+	return c.QueueDepth > 0 && c.MaxWaitMs > 0
+}
+
+// ValidateLimits rejects limits that are negative or would admit nothing.
+// Capacity may be left at zero only for the plan to fill in.
+func (c *ClientConfig) ValidateLimits() error {
+	if c.Capacity < 0 || c.RatePerSec < 0 {
+		return errors.New("capacity and rate_per_sec must not be negative")
+	}
+	if c.Capacity == 0 && c.Plan == "" {
+		return errors.New("capacity must be positive unless a plan is set")
+	}
+	if !c.Algorithm.Valid() {
+		return fmt.Errorf("unknown algorithm %q", c.Algorithm)
+	}
+	return nil
+}
+
+type Decision struct {
+	Allowed   bool
+	Limit     int
+	Remaining int
+	Cost      int
+
+	// The bucket the decision was taken from, for Refund.
+	key       string
+	capacity  int
+	rate      int
+	algorithm AlgorithmType
+	shared    bool
 }
 
 type RateLimitResponse struct {
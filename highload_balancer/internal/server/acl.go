@@ -0,0 +1,104 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/se1y4/highload-balancer/internal/ratelimiter"
+	"github.com/se1y4/highload-balancer/utils"
+)
+
+func (s *Server) handleACLAPI(w http.ResponseWriter, r *http.Request) {
+	if s.acl == nil {
+		utils.WriteErrorResponse(w, http.StatusNotImplemented, "ACL is disabled")
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		s.getACL(w, r)
+	case http.MethodPost:
+		s.addACLEntry(w, r)
+	case http.MethodDelete:
+		s.deleteACLEntry(w, r)
+	default:
+		utils.WriteErrorResponse(w, http.StatusMethodNotAllowed, "Method not allowed")
+	}
+}
+
+func (s *Server) getACL(w http.ResponseWriter, r *http.Request) {
+	action := ratelimiter.ACLAction(r.URL.Query().Get("action"))
+	if action != ratelimiter.ACLNone && action != ratelimiter.ACLAllow && action != ratelimiter.ACLDeny {
+		utils.WriteErrorResponse(w, http.StatusBadRequest, "action must be allow or deny")
+		return
+	}
+
+	utils.WriteJSONResponse(w, http.StatusOK, s.acl.List(action))
+}
+
+func (s *Server) addACLEntry(w http.ResponseWriter, r *http.Request) {
+	var request struct {
+		ratelimiter.ACLEntry
+		// TTLSeconds is a shorthand for ExpiresAt relative to now.
+		TTLSeconds int `json:"ttl_seconds,omitempty"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		utils.WriteErrorResponse(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	entry := request.ACLEntry
+	switch {
+	case request.TTLSeconds < 0:
+		utils.WriteErrorResponse(w, http.StatusBadRequest, "ttl_seconds must not be negative")
+		return
+	case request.TTLSeconds > 0:
+		expiresAt := time.Now().Add(time.Duration(request.TTLSeconds) * time.Second)
+		entry.ExpiresAt = &expiresAt
+	case entry.ExpiresAt != nil && !entry.ExpiresAt.After(time.Now()):
+		utils.WriteErrorResponse(w, http.StatusBadRequest, "expires_at must be in the future")
+		return
+	}
+
+	if err := s.acl.Add(r.Context(), &entry); err != nil {
+		if errors.Is(err, ratelimiter.ErrInvalidACLEntry) {
+			utils.WriteErrorResponse(w, http.StatusBadRequest, "cidr must be an IP or CIDR and action allow or deny")
+			return
+		}
+		if writeStorageError(w, err) {
+			return
+		}
+		log.Printf("Error adding ACL entry: %v", err)
+		utils.WriteErrorResponse(w, http.StatusInternalServerError, "Failed to add ACL entry")
+		return
+	}
+
+	utils.WriteJSONResponse(w, http.StatusCreated, entry)
+}
+
+func (s *Server) deleteACLEntry(w http.ResponseWriter, r *http.Request) {
+	cidr := r.URL.Query().Get("cidr")
+	if cidr == "" {
+		utils.WriteErrorResponse(w, http.StatusBadRequest, "cidr parameter is required")
+		return
+	}
+
+	if err := s.acl.Remove(r.Context(), cidr); err != nil {
+		switch {
+		case errors.Is(err, ratelimiter.ErrACLEntryNotFound):
+			utils.WriteErrorResponse(w, http.StatusNotFound, err.Error())
+		case errors.Is(err, ratelimiter.ErrInvalidACLEntry):
+			utils.WriteErrorResponse(w, http.StatusBadRequest, "cidr must be an IP or CIDR")
+		case writeStorageError(w, err):
+		default:
+			log.Printf("Error deleting ACL entry: %v", err)
+			utils.WriteErrorResponse(w, http.StatusInternalServerError, "Failed to delete ACL entry")
+		}
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
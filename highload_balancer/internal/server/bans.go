@@ -0,0 +1,48 @@
+package server
+
+import (
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/se1y4/highload-balancer/internal/ratelimiter"
+	"github.com/se1y4/highload-balancer/utils"
+)
+
+func (s *Server) handleBansAPI(w http.ResponseWriter, r *http.Request) {
+	if s.bans == nil {
+		utils.WriteErrorResponse(w, http.StatusNotImplemented, "Bans are disabled")
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		utils.WriteJSONResponse(w, http.StatusOK, s.bans.List())
+	case http.MethodDelete:
+		s.liftBan(w, r)
+	default:
+		utils.WriteErrorResponse(w, http.StatusMethodNotAllowed, "Method not allowed")
+	}
+}
+
+func (s *Server) liftBan(w http.ResponseWriter, r *http.Request) {
+	clientID := r.URL.Query().Get("client_id")
+	if clientID == "" {
+		utils.WriteErrorResponse(w, http.StatusBadRequest, "client_id parameter is required")
+		return
+	}
+
+	if err := s.bans.Lift(r.Context(), clientID); err != nil {
+		switch {
+		case errors.Is(err, ratelimiter.ErrBanNotFound):
+			utils.WriteErrorResponse(w, http.StatusNotFound, err.Error())
+		case writeStorageError(w, err):
+		default:
+			log.Printf("Error lifting ban: %v", err)
+			utils.WriteErrorResponse(w, http.StatusInternalServerError, "Failed to lift ban")
+		}
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,80 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/se1y4/highload-balancer/internal/ratelimiter"
+	"github.com/se1y4/highload-balancer/utils"
+)
+
+// handleThrottledAPI lists the clients rejected most often recently.
+func (s *Server) handleThrottledAPI(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		utils.WriteErrorResponse(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	n := 10
+	if top := r.URL.Query().Get("top"); top != "" {
+		var err error
+		if n, err = strconv.Atoi(top); err != nil || n <= 0 {
+			utils.WriteErrorResponse(w, http.StatusBadRequest, "top must be a positive integer")
+			return
+		}
+	}
+
+	utils.WriteJSONResponse(w, http.StatusOK, struct {
+		Window  string                        `json:"window"`
+		Clients []ratelimiter.ThrottledClient `json:"clients"`
+	}{
+		Window:  s.throttled.Window().String(),
+		Clients: s.throttled.Top(n, time.Now()),
+	})
+}
+
+func (s *Server) handleLimitsResource(w http.ResponseWriter, r *http.Request) {
+	clientID, action, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/api/limits/"), "/")
+	if clientID == "" {
+		utils.WriteErrorResponse(w, http.StatusNotFound, "Not found")
+		return
+	}
+
+	// Requests are limited under the matched client's bucket key, so
+	// resolve it the same way the proxy path does.
+	key := clientID
+	config, exists := s.clientManager.GetClientConfig(clientID)
+	if exists {
+		key = config.BucketKey(clientID)
+	}
+
+	switch {
+	case action == "" && r.Method == http.MethodGet:
+		buckets, err := s.rateLimiter.InspectBuckets(key, config)
+		if err != nil {
+			status := http.StatusInternalServerError
+			if errors.Is(err, ratelimiter.ErrSharedBuckets) {
+				status = http.StatusNotImplemented
+			}
+			utils.WriteErrorResponse(w, status, err.Error())
+			return
+		}
+		utils.WriteJSONResponse(w, http.StatusOK, struct {
+			ClientID string                   `json:"client_id"`
+			Buckets  []ratelimiter.BucketInfo `json:"buckets"`
+		}{
+			ClientID: clientID,
+			Buckets:  buckets,
+		})
+	case action == "reset" && r.Method == http.MethodPost:
+		s.rateLimiter.ResetBuckets(key, config)
+		w.WriteHeader(http.StatusNoContent)
+	case action == "" || action == "reset":
+		utils.WriteErrorResponse(w, http.StatusMethodNotAllowed, "Method not allowed")
+	default:
+		utils.WriteErrorResponse(w, http.StatusNotFound, "Not found")
+	}
+}
@@ -0,0 +1,78 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/se1y4/highload-balancer/internal/ratelimiter"
+	"github.com/se1y4/highload-balancer/utils"
+)
+
+func (s *Server) handleOverridesAPI(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		utils.WriteJSONResponse(w, http.StatusOK, s.clientManager.Overrides(r.URL.Query().Get("client_id")))
+	case http.MethodPost:
+		s.createOverride(w, r)
+	case http.MethodDelete:
+		s.deleteOverride(w, r)
+	default:
+		utils.WriteErrorResponse(w, http.StatusMethodNotAllowed, "Method not allowed")
+	}
+}
+
+func (s *Server) createOverride(w http.ResponseWriter, r *http.Request) {
+	var override ratelimiter.Override
+	if err := json.NewDecoder(r.Body).Decode(&override); err != nil {
+		utils.WriteErrorResponse(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	if err := override.Validate(); err != nil {
+		utils.WriteErrorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if err := s.clientManager.AddOverride(r.Context(), &override); err != nil {
+		switch {
+		case errors.Is(err, ratelimiter.ErrOverridesDisabled):
+			utils.WriteErrorResponse(w, http.StatusNotImplemented, err.Error())
+		case err.Error() == "client not found":
+			utils.WriteErrorResponse(w, http.StatusNotFound, err.Error())
+		case writeStorageError(w, err):
+		default:
+			log.Printf("Error creating override: %v", err)
+			utils.WriteErrorResponse(w, http.StatusInternalServerError, "Failed to create override")
+		}
+		return
+	}
+
+	w.Header().Set("Location", "/api/overrides?client_id="+override.ClientID)
+	utils.WriteJSONResponse(w, http.StatusCreated, override)
+}
+
+func (s *Server) deleteOverride(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(r.URL.Query().Get("id"), 10, 64)
+	if err != nil {
+		utils.WriteErrorResponse(w, http.StatusBadRequest, "id parameter is required")
+		return
+	}
+
+	if err := s.clientManager.RemoveOverride(r.Context(), id); err != nil {
+		switch {
+		case errors.Is(err, ratelimiter.ErrOverrideNotFound):
+			utils.WriteErrorResponse(w, http.StatusNotFound, err.Error())
+		case errors.Is(err, ratelimiter.ErrOverridesDisabled):
+			utils.WriteErrorResponse(w, http.StatusNotImplemented, err.Error())
+		case writeStorageError(w, err):
+		default:
+			log.Printf("Error deleting override: %v", err)
+			utils.WriteErrorResponse(w, http.StatusInternalServerError, "Failed to delete override")
+		}
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
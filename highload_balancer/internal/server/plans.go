@@ -0,0 +1,177 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/se1y4/highload-balancer/internal/ratelimiter"
+	"github.com/se1y4/highload-balancer/utils"
+)
+
+func (s *Server) handlePlansAPI(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		s.getPlans(w, r)
+	case http.MethodPost:
+		s.createPlan(w, r)
+	case http.MethodPatch:
+		s.patchPlan(w, r)
+	case http.MethodDelete:
+		s.deletePlan(w, r)
+	default:
+		utils.WriteErrorResponse(w, http.StatusMethodNotAllowed, "Method not allowed")
+	}
+}
+
+func (s *Server) getPlans(w http.ResponseWriter, r *http.Request) {
+	name := r.URL.Query().Get("name")
+
+	if name != "" {
+		plan, exists := s.clientManager.GetPlan(name)
+		if !exists {
+			utils.WriteErrorResponse(w, http.StatusNotFound, "Plan not found")
+			return
+		}
+		utils.WriteJSONResponse(w, http.StatusOK, plan)
+		return
+	}
+
+	utils.WriteJSONResponse(w, http.StatusOK, s.clientManager.GetAllPlans())
+}
+
+func (s *Server) createPlan(w http.ResponseWriter, r *http.Request) {
+	var plan ratelimiter.Plan
+	if err := json.NewDecoder(r.Body).Decode(&plan); err != nil {
+		utils.WriteErrorResponse(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	if plan.Name == "" {
+		utils.WriteErrorResponse(w, http.StatusBadRequest, "name is required")
+		return
+	}
+	if _, exists := s.clientManager.GetPlan(plan.Name); exists {
+		utils.WriteErrorResponse(w, http.StatusConflict, "Plan already exists")
+		return
+	}
+	if !validPlan(w, &plan) {
+		return
+	}
+
+	if err := s.clientManager.SavePlan(r.Context(), &plan); err != nil {
+		log.Printf("Error creating plan: %v", err)
+		utils.WriteErrorResponse(w, planErrorStatus(err), "Failed to create plan")
+		return
+	}
+
+	w.Header().Set("Location", "/api/plans?name="+plan.Name)
+	utils.WriteJSONResponse(w, http.StatusCreated, plan)
+}
+
+func (s *Server) patchPlan(w http.ResponseWriter, r *http.Request) {
+	name := r.URL.Query().Get("name")
+	if name == "" {
+		utils.WriteErrorResponse(w, http.StatusBadRequest, "name parameter is required")
+		return
+	}
+
+	existing, exists := s.clientManager.GetPlan(name)
+	if !exists {
+		utils.WriteErrorResponse(w, http.StatusNotFound, "Plan not found")
+		return
+	}
+	plan := *existing
+
+	var patchData struct {
+		Capacity     *int                       `json:"capacity,omitempty"`
+		RatePerSec   *int                       `json:"rate_per_sec,omitempty"`
+		Algorithm    *ratelimiter.AlgorithmType `json:"algorithm,omitempty"`
+		DailyQuota   *int64                     `json:"daily_quota,omitempty"`
+		MonthlyQuota *int64                     `json:"monthly_quota,omitempty"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&patchData); err != nil {
+		utils.WriteErrorResponse(w, http.StatusBadRequest, "Invalid patch data")
+		return
+	}
+
+	if patchData.Capacity != nil {
+		plan.Capacity = *patchData.Capacity
+	}
+	if patchData.RatePerSec != nil {
+		plan.RatePerSec = *patchData.RatePerSec
+	}
+	if patchData.Algorithm != nil {
+		plan.Algorithm = *patchData.Algorithm
+	}
+	if patchData.DailyQuota != nil {
+		plan.DailyQuota = *patchData.DailyQuota
+	}
+	if patchData.MonthlyQuota != nil {
+		plan.MonthlyQuota = *patchData.MonthlyQuota
+	}
+	if !validPlan(w, &plan) {
+		return
+	}
+
+	if err := s.clientManager.SavePlan(r.Context(), &plan); err != nil {
+		log.Printf("Error updating plan: %v", err)
+		utils.WriteErrorResponse(w, planErrorStatus(err), "Failed to update plan")
+		return
+	}
+
+	utils.WriteJSONResponse(w, http.StatusOK, plan)
+}
+
+func (s *Server) deletePlan(w http.ResponseWriter, r *http.Request) {
+	name := r.URL.Query().Get("name")
+	if name == "" {
+		utils.WriteErrorResponse(w, http.StatusBadRequest, "name parameter is required")
+		return
+	}
+
+	if err := s.clientManager.RemovePlan(r.Context(), name); err != nil {
+		switch {
+		case errors.Is(err, ratelimiter.ErrPlanNotFound):
+			utils.WriteErrorResponse(w, http.StatusNotFound, err.Error())
+		case errors.Is(err, ratelimiter.ErrPlanInUse):
+			utils.WriteErrorResponse(w, http.StatusConflict, err.Error())
+		default:
+			log.Printf("Error deleting plan: %v", err)
+			utils.WriteErrorResponse(w, planErrorStatus(err), "Failed to delete plan")
+		}
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func validPlan(w http.ResponseWriter, plan *ratelimiter.Plan) bool {
+	if !plan.Algorithm.Valid() {
+		utils.WriteErrorResponse(w, http.StatusBadRequest, "Unknown algorithm")
+		return false
+	}
+	if plan.Capacity < 0 || plan.RatePerSec < 0 || plan.DailyQuota < 0 || plan.MonthlyQuota < 0 {
+		utils.WriteErrorResponse(w, http.StatusBadRequest, "Limits must not be negative")
+		return false
+	}
+	if plan.Capacity == 0 {
+		utils.WriteErrorResponse(w, http.StatusBadRequest, "capacity must be positive")
+		return false
+	}
+	return true
+}
+
+func planErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, ratelimiter.ErrPlansDisabled):
+		return http.StatusNotImplemented
+	case ratelimiter.IsStorageTimeout(err):
+		return http.StatusGatewayTimeout
+	case ratelimiter.IsStorageUnavailable(err):
+		return http.StatusServiceUnavailable
+	}
+	return http.StatusInternalServerError
+}
@@ -0,0 +1,92 @@
+package server
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/se1y4/highload-balancer/internal/ratelimiter"
+	"github.com/se1y4/highload-balancer/utils"
+)
+
+type readiness struct {
+	Status  string                    `json:"status"`
+	Storage ratelimiter.StorageStatus `json:"storage"`
+	// Snapshot is set while clients come from the local snapshot rather
+	// than storage.
+	Snapshot bool `json:"snapshot"`
+}
+
+// handleReady reports "degraded" while storage is down: requests are
+// still limited, but limits cannot be changed. It still answers 200, as
+// every replica degrades together when storage goes, unless told to fail
+// so load balancers drain it.
+func (s *Server) handleReady(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		utils.WriteErrorResponse(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	ready := readiness{
+		Status:   "ready",
+		Storage:  s.clientManager.StorageStatus(),
+		Snapshot: s.clientManager.Stale(),
+	}
+	status := http.StatusOK
+	if !ready.Storage.Healthy || ready.Snapshot {
+		ready.Status = "degraded"
+		if s.readyFailWhenDegraded {
+			status = http.StatusServiceUnavailable
+		}
+	}
+	utils.WriteJSONResponse(w, status, ready)
+}
+
+// SetReadyFailWhenDegraded makes /ready answer 503 while degraded, for
+// deployments that would rather drain an instance than serve from the
+// snapshot.
+func (s *Server) SetReadyFailWhenDegraded(fail bool) {
+	s.readyFailWhenDegraded = fail
+}
+
+// rejectStorageWrite turns away admin changes that must be persisted
+// when storage is read-only or unreachable, rather than applying them
+// only locally.
+func (s *Server) rejectStorageWrite(w http.ResponseWriter, r *http.Request) bool {
+	if r.Method == http.MethodGet || !storagePath(r.URL.Path) {
+		return false
+	}
+	if s.clientManager.ReadOnly() {
+		utils.WriteErrorResponse(w, http.StatusMethodNotAllowed, "Storage is read-only")
+		return true
+	}
+	if s.clientManager.StorageStatus().Healthy {
+		return false
+	}
+	w.Header().Set("Retry-After", "5")
+	utils.WriteErrorResponse(w, http.StatusServiceUnavailable, "Storage unavailable, changes are not accepted")
+	return true
+}
+
+// writeStorageError answers with 504 when a storage call timed out and
+// 503 when storage could not be reached. It reports whether err was
+// either.
+func writeStorageError(w http.ResponseWriter, err error) bool {
+	switch {
+	case ratelimiter.IsStorageTimeout(err):
+		utils.WriteErrorResponse(w, http.StatusGatewayTimeout, "Storage timed out")
+	case ratelimiter.IsStorageUnavailable(err):
+		w.Header().Set("Retry-After", "5")
+		utils.WriteErrorResponse(w, http.StatusServiceUnavailable, "Storage unavailable")
+	default:
+		return false
+	}
+	return true
+}
+
+func storagePath(path string) bool {
+	switch path {
+	case "/api/clients", "/api/plans", "/api/overrides", "/api/acl":
+		return true
+	}
+	return strings.HasPrefix(path, "/api/clients/")
+}
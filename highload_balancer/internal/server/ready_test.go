@@ -0,0 +1,35 @@
+package server
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/se1y4/highload-balancer/internal/ratelimiter"
+)
+
+// downStorage reports itself unreachable.
+type downStorage struct {
+	*ratelimiter.BoltStorage
+}
+
+func (downStorage) Status() ratelimiter.StorageStatus {
+	return ratelimiter.StorageStatus{LastError: "connection refused"}
+}
+
+func TestReady(t *testing.T) {
+	s := newTestServer(t, nil)
+	if w := s.do("GET", "/ready", "192.0.2.1:1000", nil, nil); w.Code != http.StatusOK {
+		t.Fatalf("healthy instance got %d, want 200", w.Code)
+	}
+
+	s.clientManager = ratelimiter.NewClientManager(downStorage{s.storage}, s.rateLimiter)
+	w := s.do("GET", "/ready", "192.0.2.1:1000", nil, nil)
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"status":"degraded"`) {
+		t.Fatalf("degraded instance got %d %s, want 200 and degraded", w.Code, w.Body)
+	}
+	s.SetReadyFailWhenDegraded(true)
+	if w := s.do("GET", "/ready", "192.0.2.1:1000", nil, nil); w.Code != http.StatusServiceUnavailable {
+		t.Fatalf("degraded instance told to fail got %d, want 503", w.Code)
+	}
+}
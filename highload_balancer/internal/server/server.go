@@ -1,12 +1,19 @@
 package server
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"log"
 	"net/http"
+	"net/netip"
+	"strconv"
+	"strings"
 	"time"
 
+	"github.com/se1y4/highload-balancer/internal/adaptive"
 	"github.com/se1y4/highload-balancer/internal/balancer"
+	"github.com/se1y4/highload-balancer/internal/metrics"
 	"github.com/se1y4/highload-balancer/internal/ratelimiter"
 	"github.com/se1y4/highload-balancer/utils"
 )
@@ -15,36 +22,134 @@ type Server struct {
 	balancer      *balancer.LoadBalancer
 	rateLimiter   *ratelimiter.RateLimiter
 	clientManager *ratelimiter.ClientManager
+	quotas        *ratelimiter.QuotaTracker
+	concurrency   *ratelimiter.ConcurrencyLimiter
+	throttled     *ratelimiter.ThrottleStats
+	adaptive      *adaptive.Limiter
+	shedder       *adaptive.Shedder
+	acl           *ratelimiter.ACL
+	bans          *ratelimiter.BanTracker
+
+	trustedProxies        []netip.Prefix
+	readyFailWhenDegraded bool
 }
 
-func NewServer(balancer *balancer.LoadBalancer, rateLimiter *ratelimiter.RateLimiter, clientManager *ratelimiter.ClientManager) *Server {
+func NewServer(balancer *balancer.LoadBalancer, rateLimiter *ratelimiter.RateLimiter, clientManager *ratelimiter.ClientManager, quotas *ratelimiter.QuotaTracker, adaptive *adaptive.Limiter, shedder *adaptive.Shedder, acl *ratelimiter.ACL, bans *ratelimiter.BanTracker) *Server {
 	return &Server{
 		balancer:      balancer,
 		rateLimiter:   rateLimiter,
 		clientManager: clientManager,
+		quotas:        quotas,
+		concurrency:   ratelimiter.NewConcurrencyLimiter(),
+		throttled:     ratelimiter.NewThrottleStats(5 * time.Minute),
+		adaptive:      adaptive,
+		shedder:       shedder,
+		acl:           acl,
+		bans:          bans,
 	}
 }
 
+// SetTrustedProxies sets the proxies whose X-Forwarded-For and X-Real-Ip
+// headers name the client. Requests from anywhere else are identified by
+// their remote address.
+func (s *Server) SetTrustedProxies(prefixes []netip.Prefix) {
+	s.trustedProxies = prefixes
+}
+
 func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	start := time.Now()
 	defer func() {
 		log.Printf("%s %s processed in %v", r.Method, r.URL.Path, time.Since(start))
 	}()
 
-	switch {
-	case utils.IsHealthCheckRequest(r):
+	if utils.IsHealthCheckRequest(r) {
 		w.WriteHeader(http.StatusOK)
 		return
+	}
+	if r.URL.Path == "/ready" {
+		s.handleReady(w, r)
+		return
+	}
 
+	if s.rejectStorageWrite(w, r) {
+		return
+	}
+
+	switch {
 	case r.URL.Path == "/api/clients":
 		s.handleClientsAPI(w, r)
 		return
 
+	case strings.HasPrefix(r.URL.Path, "/api/clients/"):
+		s.handleClientResource(w, r)
+		return
+
+	case r.URL.Path == "/api/plans":
+		s.handlePlansAPI(w, r)
+		return
+
+	case r.URL.Path == "/api/limits":
+		s.handleThrottledAPI(w, r)
+		return
+
+	case strings.HasPrefix(r.URL.Path, "/api/limits/"):
+		s.handleLimitsResource(w, r)
+		return
+
+	case r.URL.Path == "/api/overrides":
+		s.handleOverridesAPI(w, r)
+		return
+
+	case r.URL.Path == "/api/acl":
+		s.handleACLAPI(w, r)
+		return
+
+	case r.URL.Path == "/api/bans":
+		s.handleBansAPI(w, r)
+		return
+
+	case r.URL.Path == "/metrics":
+		metrics.Handler().ServeHTTP(w, r)
+		return
+
 	default:
-		s.handleProxyRequest(w, r)
+		clientIP := utils.ClientIP(r, s.trustedProxies)
+		if exempt, ok := s.checkAccess(w, clientIP); ok {
+			s.handleProxyRequest(w, r, clientIP, exempt)
+		}
 	}
 }
 
+// checkAccess applies the ACL and bans to a proxied request. The admin
+// API is not subject to them, so a deny rule cannot lock operators out.
+// It reports whether the client is exempt from limits and whether the
+// request may go on.
+func (s *Server) checkAccess(w http.ResponseWriter, clientIP string) (exempt, ok bool) {
+	access := ratelimiter.ACLNone
+	if s.acl != nil {
+		access = s.acl.Lookup(clientIP)
+	}
+	if access == ratelimiter.ACLDeny {
+		metrics.Rejections.Add("acl", 1)
+		utils.WriteErrorResponse(w, http.StatusForbidden, "Access denied")
+		return false, false
+	}
+
+	if s.bans != nil && access != ratelimiter.ACLAllow {
+		now := time.Now()
+		if until, banned := s.bans.Banned(clientIP, now); banned {
+			metrics.Rejections.Add("ban", 1)
+			utils.WriteJSONResponse(w, http.StatusTooManyRequests, ratelimiter.RateLimitResponse{
+				Code:       http.StatusTooManyRequests,
+				Message:    "Client temporarily banned",
+				RetryAfter: until.Sub(now),
+			})
+			return false, false
+		}
+	}
+	return access == ratelimiter.ACLAllow, true
+}
+
 func (s *Server) handleClientsAPI(w http.ResponseWriter, r *http.Request) {
 	switch r.Method {
 	case http.MethodGet:
@@ -64,7 +169,7 @@ func (s *Server) getClients(w http.ResponseWriter, r *http.Request) {
 	clientID := r.URL.Query().Get("client_id")
 
 	if clientID != "" {
-		client, exists := s.clientManager.GetClientConfig(clientID)
+		client, exists := s.clientManager.GetClient(clientID)
 		if !exists {
 			utils.WriteErrorResponse(w, http.StatusNotFound, "Client not found")
 			return
@@ -89,12 +194,59 @@ func (s *Server) createClient(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if err := s.clientManager.AddClient(config.ClientID, config.Capacity, config.RatePerSec); err != nil {
+	if err := ratelimiter.ValidateClientID(config.ClientID); err != nil {
+		utils.WriteErrorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if !config.Algorithm.Valid() {
+		utils.WriteErrorResponse(w, http.StatusBadRequest, "Unknown algorithm")
+		return
+	}
+
+	if err := config.ValidateLimits(); err != nil {
+		utils.WriteErrorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if config.DailyQuota < 0 || config.MonthlyQuota < 0 || config.QuotaResetDay < 0 || config.QuotaResetDay > 28 {
+		utils.WriteErrorResponse(w, http.StatusBadRequest, "Invalid quota settings")
+		return
+	}
+
+	if err := ratelimiter.ValidateRouteRules(config.Routes); err != nil {
+		utils.WriteErrorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if config.MaxConcurrent < 0 {
+		utils.WriteErrorResponse(w, http.StatusBadRequest, "max_concurrent must not be negative")
+		return
+	}
+
+	if config.QueueDepth < 0 || config.MaxWaitMs < 0 {
+		utils.WriteErrorResponse(w, http.StatusBadRequest, "queue_depth and max_wait_ms must not be negative")
+		return
+	}
+
+	if config.Priority < 0 {
+		utils.WriteErrorResponse(w, http.StatusBadRequest, "priority must not be negative")
+		return
+	}
+
+	if err := s.clientManager.AddClient(r.Context(), &config); err != nil {
+		if errors.Is(err, ratelimiter.ErrPlanNotFound) {
+			utils.WriteErrorResponse(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if writeStorageError(w, err) {
+			return
+		}
 		utils.WriteErrorResponse(w, http.StatusInternalServerError, "Failed to create client")
 		return
 	}
 
-	client, _ := s.clientManager.GetClientConfig(config.ClientID)
+	client, _ := s.clientManager.GetClient(config.ClientID)
 	w.Header().Set("Location", "/api/clients?client_id="+config.ClientID)
 	utils.WriteJSONResponse(w, http.StatusCreated, client)
 }
@@ -119,10 +271,10 @@ func (s *Server) deleteClient(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if err := s.clientManager.RemoveClient(clientID); err != nil {
+	if err := s.clientManager.RemoveClient(r.Context(), clientID); err != nil {
 		if err.Error() == "client not found" {
 			utils.WriteErrorResponse(w, http.StatusNotFound, err.Error())
-		} else {
+		} else if !writeStorageError(w, err) {
 			log.Printf("Error deleting client: %v", err)
 			utils.WriteErrorResponse(w, http.StatusInternalServerError, "Failed to delete client")
 		}
@@ -132,29 +284,180 @@ func (s *Server) deleteClient(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusNoContent)
 }
 
-func (s *Server) handleProxyRequest(w http.ResponseWriter, r *http.Request) {
-	clientIP := utils.GetClientIP(r)
+// handleProxyRequest applies client limits and forwards the request.
+// Exempt requests, from allowlisted addresses, skip the limits.
+func (s *Server) handleProxyRequest(w http.ResponseWriter, r *http.Request, clientIP string, exempt bool) {
+	trusted := utils.IsTrustedProxy(r, s.trustedProxies)
+	if !trusted {
+		s.rateLimiter.StripCostHeader(r)
+	}
+	// Allowlisted clients, typically monitoring, are neither limited nor
+	// shed, so probes keep working while the service is overloaded.
+	if exempt {
+		s.forward(w, r)
+		return
+	}
+
 	clientConfig, exists := s.clientManager.GetClientConfig(clientIP)
 
-	var allowed bool
+	if s.shedder != nil {
+		priority := 0
+		if exists {
+			priority = clientConfig.Priority
+		}
+		// Shed before rate limiting so rejected requests cost no tokens.
+		if !s.shedder.Admit(priority) {
+			metrics.Rejections.Add("shed", 1)
+			utils.WriteJSONResponse(w, http.StatusServiceUnavailable, ratelimiter.RateLimitResponse{
+				Code:    http.StatusServiceUnavailable,
+				Message: "Service overloaded",
+			})
+			return
+		}
+	}
+
+	// Limits are kept per address unless a pattern client shares one
+	// bucket across all of its addresses.
+	clientKey := clientIP
+	var routeConfig *ratelimiter.ClientConfig
 	if exists {
-		allowed = s.rateLimiter.AllowWithConfig(clientIP, clientConfig)
-	} else {
-		allowed = s.rateLimiter.Allow(clientIP)
+		clientKey = clientConfig.BucketKey(clientIP)
+		routeConfig = clientConfig
 	}
+	rule := s.rateLimiter.MatchRoute(routeConfig, r.Method, r.Host, r.URL.Path)
+	cost := s.rateLimiter.RequestCost(r, rule, trusted)
 
-	if !allowed {
-		utils.WriteJSONResponse(w, http.StatusTooManyRequests, ratelimiter.RateLimitResponse{
-			Code:       http.StatusTooManyRequests,
-			Message:    "Rate limit exceeded",
-			RetryAfter: time.Second,
-		})
+	waiting := exists && clientConfig.WaitEnabled()
+
+	var (
+		decision ratelimiter.Decision
+		err      error
+	)
+	switch {
+	case waiting:
+		decision, err = s.rateLimiter.WaitWithConfig(r.Context(), clientKey, clientConfig, cost)
+	case exists:
+		decision = s.rateLimiter.AllowWithConfig(clientKey, clientConfig, cost)
+	default:
+		decision = s.rateLimiter.Allow(clientKey, cost)
+	}
+	writeRateLimitHeaders(w, decision)
+
+	if !decision.Allowed {
+		writeRateLimited(w, r, decision, err, "Rate limit exceeded")
+		s.recordRejection(clientIP)
 		return
 	}
 
+	// Tokens are only spent on requests that reach the backend: when a
+	// later check rejects, the earlier ones are refunded.
+	var routeDecision ratelimiter.Decision
+	refund := func() {
+		s.rateLimiter.Refund(decision)
+		s.rateLimiter.Refund(routeDecision)
+	}
+
+	if rule != nil {
+		if waiting {
+			routeDecision, err = s.rateLimiter.WaitRoute(r.Context(), clientKey, rule, clientConfig, cost)
+		} else {
+			routeDecision = s.rateLimiter.AllowRoute(clientKey, rule, cost)
+		}
+		if !routeDecision.Allowed {
+			refund()
+			writeRateLimitHeaders(w, routeDecision)
+			writeRateLimited(w, r, routeDecision, err, "Rate limit exceeded for route "+rule.Name)
+			s.recordRejection(clientIP)
+			return
+		}
+	}
+
+	// The slot is taken before quota is charged, since quota usage is
+	// persisted and cannot be refunded.
+	if exists && clientConfig.MaxConcurrent > 0 {
+		release, ok := s.concurrency.Acquire(clientKey, clientConfig.MaxConcurrent)
+		if !ok {
+			refund()
+			metrics.Rejections.Add("concurrency", 1)
+			s.recordRejection(clientIP)
+			utils.WriteJSONResponse(w, http.StatusTooManyRequests, ratelimiter.RateLimitResponse{
+				Code:    http.StatusTooManyRequests,
+				Message: "Too many concurrent requests",
+			})
+			return
+		}
+		// Free the slot as soon as the client goes away, even if the
+		// backend is still working on the request.
+		stop := context.AfterFunc(r.Context(), release)
+		defer stop()
+		defer release()
+	}
+
+	if exists && s.quotas != nil {
+		now := time.Now()
+		if usage, ok := s.quotas.Consume(clientKey, clientConfig, now); !ok {
+			refund()
+			metrics.Rejections.Add("quota", 1)
+			s.recordRejection(clientIP)
+			message := "Monthly quota exceeded"
+			if usage.Period == ratelimiter.DailyQuota {
+				message = "Daily quota exceeded"
+			}
+			utils.WriteJSONResponse(w, http.StatusTooManyRequests, ratelimiter.RateLimitResponse{
+				Code:       http.StatusTooManyRequests,
+				Message:    message,
+				RetryAfter: usage.ResetsAt.Sub(now),
+			})
+			return
+		}
+	}
+
+	s.forward(w, r)
+}
+
+func (s *Server) forward(w http.ResponseWriter, r *http.Request) {
+	if s.shedder != nil {
+		defer s.shedder.Track()()
+	}
+
+	if s.adaptive != nil {
+		s.proxyAdaptive(w, r)
+		return
+	}
 	s.balancer.ServeHTTP(w, r)
 }
 
+// proxyAdaptive forwards the request only if the adaptive limiter has a
+// free slot, and feeds the backend's latency back into it.
+func (s *Server) proxyAdaptive(w http.ResponseWriter, r *http.Request) {
+	release, ok := s.adaptive.Acquire()
+	if !ok {
+		metrics.Rejections.Add("overload", 1)
+		utils.WriteJSONResponse(w, http.StatusServiceUnavailable, ratelimiter.RateLimitResponse{
+			Code:    http.StatusServiceUnavailable,
+			Message: "Service overloaded",
+		})
+		return
+	}
+
+	recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+	start := time.Now()
+	// Deferred so the slot is returned even if the proxy aborts the
+	// handler with a panic.
+	defer func() {
+		outcome := adaptive.Success
+		switch {
+		case r.Context().Err() != nil:
+			outcome = adaptive.Ignored
+		case recorder.status >= http.StatusInternalServerError:
+			outcome = adaptive.Dropped
+		}
+		release(time.Since(start), outcome)
+	}()
+
+	s.balancer.ServeHTTP(recorder, r)
+}
+
 func (s *Server) patchClient(w http.ResponseWriter, r *http.Request) {
 	clientID := r.URL.Query().Get("client_id")
 	if clientID == "" {
@@ -162,15 +465,28 @@ func (s *Server) patchClient(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	currentClient, exists := s.clientManager.GetClientConfig(clientID)
+	existing, exists := s.clientManager.GetClient(clientID)
 	if !exists {
 		utils.WriteErrorResponse(w, http.StatusNotFound, "Client not found")
 		return
 	}
+	// The stored config is shared with the request path; patch a copy.
+	currentClient := *existing
 
 	var patchData struct {
-		Capacity   *int `json:"capacity,omitempty"`
-		RatePerSec *int `json:"rate_per_sec,omitempty"`
+		Capacity      *int                       `json:"capacity,omitempty"`
+		RatePerSec    *int                       `json:"rate_per_sec,omitempty"`
+		Algorithm     *ratelimiter.AlgorithmType `json:"algorithm,omitempty"`
+		DailyQuota    *int64                     `json:"daily_quota,omitempty"`
+		MonthlyQuota  *int64                     `json:"monthly_quota,omitempty"`
+		QuotaResetDay *int                       `json:"quota_reset_day,omitempty"`
+		Plan          *string                    `json:"plan,omitempty"`
+		Routes        *[]ratelimiter.RouteRule   `json:"routes,omitempty"`
+		MaxConcurrent *int                       `json:"max_concurrent,omitempty"`
+		QueueDepth    *int                       `json:"queue_depth,omitempty"`
+		MaxWaitMs     *int                       `json:"max_wait_ms,omitempty"`
+		Priority      *int                       `json:"priority,omitempty"`
+		SharedBucket  *bool                      `json:"shared_bucket,omitempty"`
 	}
 
 	if err := json.NewDecoder(r.Body).Decode(&patchData); err != nil {
@@ -184,11 +500,158 @@ func (s *Server) patchClient(w http.ResponseWriter, r *http.Request) {
 	if patchData.RatePerSec != nil {
 		currentClient.RatePerSec = *patchData.RatePerSec
 	}
+	if patchData.Algorithm != nil {
+		if !patchData.Algorithm.Valid() {
+			utils.WriteErrorResponse(w, http.StatusBadRequest, "Unknown algorithm")
+			return
+		}
+		currentClient.Algorithm = *patchData.Algorithm
+	}
+	if patchData.DailyQuota != nil {
+		currentClient.DailyQuota = *patchData.DailyQuota
+	}
+	if patchData.MonthlyQuota != nil {
+		currentClient.MonthlyQuota = *patchData.MonthlyQuota
+	}
+	if patchData.QuotaResetDay != nil {
+		currentClient.QuotaResetDay = *patchData.QuotaResetDay
+	}
+	if patchData.Plan != nil {
+		currentClient.Plan = *patchData.Plan
+	}
+	if err := currentClient.ValidateLimits(); err != nil {
+		utils.WriteErrorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if patchData.Routes != nil {
+		if err := ratelimiter.ValidateRouteRules(*patchData.Routes); err != nil {
+			utils.WriteErrorResponse(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		currentClient.Routes = *patchData.Routes
+	}
+	if patchData.MaxConcurrent != nil {
+		if *patchData.MaxConcurrent < 0 {
+			utils.WriteErrorResponse(w, http.StatusBadRequest, "max_concurrent must not be negative")
+			return
+		}
+		currentClient.MaxConcurrent = *patchData.MaxConcurrent
+	}
+	if patchData.QueueDepth != nil {
+		currentClient.QueueDepth = *patchData.QueueDepth
+	}
+	if patchData.MaxWaitMs != nil {
+		currentClient.MaxWaitMs = *patchData.MaxWaitMs
+	}
+	if currentClient.QueueDepth < 0 || currentClient.MaxWaitMs < 0 {
+		utils.WriteErrorResponse(w, http.StatusBadRequest, "queue_depth and max_wait_ms must not be negative")
+		return
+	}
+	if patchData.Priority != nil {
+		if *patchData.Priority < 0 {
+			utils.WriteErrorResponse(w, http.StatusBadRequest, "priority must not be negative")
+			return
+		}
+		currentClient.Priority = *patchData.Priority
+	}
+	if patchData.SharedBucket != nil {
+		currentClient.SharedBucket = *patchData.SharedBucket
+	}
+	if currentClient.DailyQuota < 0 || currentClient.MonthlyQuota < 0 || currentClient.QuotaResetDay < 0 || currentClient.QuotaResetDay > 28 {
+		utils.WriteErrorResponse(w, http.StatusBadRequest, "Invalid quota settings")
+		return
+	}
 
-	if err := s.clientManager.UpdateClient(currentClient); err != nil {
+	if err := s.clientManager.UpdateClient(r.Context(), &currentClient); err != nil {
+		if errors.Is(err, ratelimiter.ErrPlanNotFound) {
+			utils.WriteErrorResponse(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if writeStorageError(w, err) {
+			return
+		}
 		utils.WriteErrorResponse(w, http.StatusInternalServerError, "Failed to update client")
 		return
 	}
 
 	utils.WriteJSONResponse(w, http.StatusOK, currentClient)
 }
+
+func (s *Server) handleClientResource(w http.ResponseWriter, r *http.Request) {
+	clientID, resource, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/api/clients/"), "/")
+	if clientID == "" {
+		utils.WriteErrorResponse(w, http.StatusNotFound, "Not found")
+		return
+	}
+
+	switch resource {
+	case "usage":
+		if r.Method != http.MethodGet {
+			utils.WriteErrorResponse(w, http.StatusMethodNotAllowed, "Method not allowed")
+			return
+		}
+		s.getClientUsage(w, clientID)
+	default:
+		utils.WriteErrorResponse(w, http.StatusNotFound, "Not found")
+	}
+}
+
+func (s *Server) getClientUsage(w http.ResponseWriter, clientID string) {
+	client, exists := s.clientManager.GetClientConfig(clientID)
+	if !exists {
+		utils.WriteErrorResponse(w, http.StatusNotFound, "Client not found")
+		return
+	}
+	if s.quotas == nil {
+		utils.WriteErrorResponse(w, http.StatusNotImplemented, "Quotas are disabled")
+		return
+	}
+
+	utils.WriteJSONResponse(w, http.StatusOK, struct {
+		ClientID string                   `json:"client_id"`
+		Quotas   []ratelimiter.QuotaUsage `json:"quotas"`
+	}{
+		ClientID: clientID,
+		Quotas:   s.quotas.Usage(client.BucketKey(clientID), client, time.Now()),
+	})
+}
+
+// recordRejection counts a limit the client ran into towards a ban and
+// the throttled clients listing.
+func (s *Server) recordRejection(clientID string) {
+	now := time.Now()
+	s.throttled.Record(clientID, now)
+	if s.bans != nil {
+		s.bans.RecordRejection(clientID, now)
+	}
+}
+
+func writeRateLimitHeaders(w http.ResponseWriter, decision ratelimiter.Decision) {
+	w.Header().Set("X-RateLimit-Limit", strconv.Itoa(decision.Limit))
+	w.Header().Set("X-RateLimit-Remaining", strconv.Itoa(decision.Remaining))
+	w.Header().Set("X-RateLimit-Cost", strconv.Itoa(decision.Cost))
+}
+
+// writeRateLimited rejects a request that did not get a token. waitErr
+// is the reason a queued request gave up, if it was queued.
+func writeRateLimited(w http.ResponseWriter, r *http.Request, decision ratelimiter.Decision, waitErr error, message string) {
+	if r.Context().Err() != nil {
+		// The client left while queued; nobody is reading the answer.
+		return
+	}
+
+	metrics.Rejections.Add("rate_limit", 1)
+	switch {
+	case decision.Cost > decision.Limit:
+		message = "Request cost exceeds rate limit capacity"
+	case errors.Is(waitErr, ratelimiter.ErrQueueFull):
+		message = "Rate limit exceeded and wait queue is full"
+	case errors.Is(waitErr, ratelimiter.ErrWaitTimeout):
+		message = "Rate limit exceeded after waiting in queue"
+	}
+	utils.WriteJSONResponse(w, http.StatusTooManyRequests, ratelimiter.RateLimitResponse{
+		Code:       http.StatusTooManyRequests,
+		Message:    message,
+		RetryAfter: time.Second,
+	})
+}
@@ -0,0 +1,307 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/se1y4/highload-balancer/internal/adaptive"
+	"github.com/se1y4/highload-balancer/internal/balancer"
+	"github.com/se1y4/highload-balancer/internal/ratelimiter"
+	"github.com/se1y4/highload-balancer/utils"
+)
+
+type testServer struct {
+	*Server
+	storage *ratelimiter.BoltStorage
+}
+
+// newTestServer returns a server in front of a backend that answers 200,
+// with clients kept in a temporary bolt file.
+func newTestServer(t *testing.T, config *ratelimiter.Config, clients ...*ratelimiter.ClientConfig) *testServer {
+	t.Helper()
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	t.Cleanup(backend.Close)
+
+	storage, err := ratelimiter.NewBoltStorage(filepath.Join(t.TempDir(), "clients.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { storage.Close() })
+	for _, client := range clients {
+		if err := storage.SaveClient(context.Background(), client); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	rl := ratelimiter.NewRateLimiter(100, 0, config)
+	cm := ratelimiter.NewClientManager(storage, rl)
+	lb := balancer.NewLoadBalancer([]string{backend.URL}, balancer.NewStrategy(balancer.RoundRobinStrategy), nil)
+	return &testServer{
+		Server:  NewServer(lb, rl, cm, nil, nil, nil, nil, nil),
+		storage: storage,
+	}
+}
+
+// do sends a request from remoteAddr and returns the status code.
+func (s *testServer) do(method, path, remoteAddr string, header http.Header, body any) *httptest.ResponseRecorder {
+	var buf bytes.Buffer
+	if body != nil {
+		json.NewEncoder(&buf).Encode(body)
+	}
+	r := httptest.NewRequest(method, path, &buf)
+	r.RemoteAddr = remoteAddr
+	for name, values := range header {
+		r.Header[name] = values
+	}
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, r)
+	return w
+}
+
+func countOK(s *testServer, method, path, remoteAddr string, n int) int {
+	ok := 0
+	for i := 0; i < n; i++ {
+		if s.do(method, path, remoteAddr, nil, nil).Code == http.StatusOK {
+			ok++
+		}
+	}
+	return ok
+}
+
+func TestRouteRejectionRefundsGlobalTokens(t *testing.T) {
+	s := newTestServer(t, &ratelimiter.Config{
+		Routes: []ratelimiter.RouteRule{{Name: "reports", Method: "POST", PathPrefix: "/reports", Capacity: 1}},
+	}, &ratelimiter.ClientConfig{ClientID: "192.0.2.1", Capacity: 10})
+
+	if got := countOK(s, "POST", "/reports", "192.0.2.1:1000", 5); got != 1 {
+		t.Fatalf("%d report requests admitted, want 1", got)
+	}
+	// Requests rejected by the route must not have drained the rest of
+	// the client's budget.
+	if got := countOK(s, "GET", "/status", "192.0.2.1:1000", 20); got != 9 {
+		t.Fatalf("%d other requests admitted, want 9", got)
+	}
+}
+
+func TestConcurrencyRejectionKeepsQuota(t *testing.T) {
+	client := &ratelimiter.ClientConfig{ClientID: "192.0.2.1", Capacity: 10, MaxConcurrent: 1, DailyQuota: 100}
+	s := newTestServer(t, nil, client)
+	s.quotas = ratelimiter.NewQuotaTracker(nil, nil, time.Hour)
+	t.Cleanup(s.quotas.Stop)
+
+	release, _ := s.concurrency.Acquire("192.0.2.1", 1)
+	w := s.do("GET", "/", "192.0.2.1:1000", nil, nil)
+	release()
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("status %d with the only slot taken, want 429", w.Code)
+	}
+
+	for _, usage := range s.quotas.Usage("192.0.2.1", client, time.Now()) {
+		if usage.Used != 0 {
+			t.Fatalf("%s quota used %d after a concurrency rejection, want 0", usage.Period, usage.Used)
+		}
+	}
+	// Nor did the rejection take rate limit tokens.
+	if got := countOK(s, "GET", "/", "192.0.2.1:1000", 20); got != 10 {
+		t.Fatalf("%d requests admitted after the rejection, want 10", got)
+	}
+}
+
+func newACL(t *testing.T, s *testServer, entries ...*ratelimiter.ACLEntry) {
+	t.Helper()
+	s.acl = ratelimiter.NewACL(s.storage)
+	for _, entry := range entries {
+		if err := s.acl.Add(context.Background(), entry); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestForwardedForIgnoredFromUntrustedPeers(t *testing.T) {
+	s := newTestServer(t, nil, &ratelimiter.ClientConfig{ClientID: "203.0.113.7", Capacity: 1})
+	newACL(t, s,
+		&ratelimiter.ACLEntry{CIDR: "192.0.2.10/32", Action: ratelimiter.ACLAllow},
+		&ratelimiter.ACLEntry{CIDR: "198.51.100.0/24", Action: ratelimiter.ACLDeny},
+	)
+
+	// Claiming an allowlisted address does not lift the limit.
+	spoofed := http.Header{"X-Forwarded-For": {"192.0.2.10"}, "X-Real-Ip": {"192.0.2.10"}}
+	ok := 0
+	for i := 0; i < 5; i++ {
+		if s.do("GET", "/", "203.0.113.7:1000", spoofed, nil).Code == http.StatusOK {
+			ok++
+		}
+	}
+	if ok != 1 {
+		t.Fatalf("%d requests admitted with a spoofed X-Forwarded-For, want 1", ok)
+	}
+
+	// Nor does claiming some other address get a denied client through.
+	other := http.Header{"X-Forwarded-For": {"203.0.113.99"}}
+	if w := s.do("GET", "/", "198.51.100.1:1000", other, nil); w.Code != http.StatusForbidden {
+		t.Fatalf("denied address with a spoofed X-Forwarded-For got %d, want 403", w.Code)
+	}
+}
+
+func TestForwardedForFromTrustedProxies(t *testing.T) {
+	s := newTestServer(t, nil)
+	newACL(t, s, &ratelimiter.ACLEntry{CIDR: "198.51.100.0/24", Action: ratelimiter.ACLDeny})
+	trusted, err := utils.ParseTrustedProxies([]string{"10.0.0.0/8", "127.0.0.1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.SetTrustedProxies(trusted)
+
+	for _, c := range []struct {
+		xff  string
+		want int
+	}{
+		{"198.51.100.1", http.StatusForbidden},
+		// The client is the last hop not added by a trusted proxy, so
+		// whatever it put in front of that does not count.
+		{"198.51.100.1, 203.0.113.7", http.StatusOK},
+		{"203.0.113.7, 198.51.100.1, 10.1.1.1", http.StatusForbidden},
+	} {
+		header := http.Header{"X-Forwarded-For": {c.xff}}
+		if w := s.do("GET", "/", "10.0.0.1:1000", header, nil); w.Code != c.want {
+			t.Errorf("X-Forwarded-For %q got %d, want %d", c.xff, w.Code, c.want)
+		}
+	}
+}
+
+func TestACLDenyLeavesAdminAPI(t *testing.T) {
+	s := newTestServer(t, nil)
+	newACL(t, s, &ratelimiter.ACLEntry{CIDR: "192.0.2.0/24", Action: ratelimiter.ACLDeny})
+
+	if w := s.do("GET", "/", "192.0.2.1:1000", nil, nil); w.Code != http.StatusForbidden {
+		t.Fatalf("proxied request from a denied address got %d, want 403", w.Code)
+	}
+	if w := s.do("DELETE", "/api/acl?cidr=192.0.2.0/24", "192.0.2.1:1000", nil, nil); w.Code != http.StatusNoContent {
+		t.Fatalf("removing the rule from a denied address got %d, want 204", w.Code)
+	}
+	if w := s.do("GET", "/", "192.0.2.1:1000", nil, nil); w.Code != http.StatusOK {
+		t.Fatalf("request after the rule was removed got %d, want 200", w.Code)
+	}
+}
+
+func TestSpoofedForwardedForCannotBanVictim(t *testing.T) {
+	s := newTestServer(t, nil,
+		&ratelimiter.ClientConfig{ClientID: "203.0.113.7", Capacity: 1},
+		&ratelimiter.ClientConfig{ClientID: "192.0.2.10", Capacity: 10},
+	)
+	s.bans = ratelimiter.NewBanTracker(&ratelimiter.BanConfig{Threshold: 3, Window: time.Minute}, nil)
+	t.Cleanup(s.bans.Stop)
+
+	// The attacker runs into its own limit while claiming to be the victim.
+	spoofed := http.Header{"X-Forwarded-For": {"192.0.2.10"}}
+	for i := 0; i < 10; i++ {
+		s.do("GET", "/", "203.0.113.7:1000", spoofed, nil)
+	}
+
+	if _, banned := s.bans.Banned("192.0.2.10", time.Now()); banned {
+		t.Fatal("victim banned by requests from another address")
+	}
+	if w := s.do("GET", "/", "192.0.2.10:1000", nil, nil); w.Code != http.StatusOK {
+		t.Fatalf("victim got %d, want 200", w.Code)
+	}
+	if _, banned := s.bans.Banned("203.0.113.7", time.Now()); !banned {
+		t.Fatal("attacker not banned")
+	}
+}
+
+func TestCostHeaderOnlyFromTrustedProxies(t *testing.T) {
+	var seen []string
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = append(seen, r.Header.Get("X-Cost"))
+	}))
+	defer backend.Close()
+
+	s := newTestServer(t, &ratelimiter.Config{
+		Routes:     []ratelimiter.RouteRule{{Name: "reports", PathPrefix: "/reports", Capacity: 100, Cost: 5}},
+		CostHeader: "X-Cost",
+	}, &ratelimiter.ClientConfig{ClientID: "*", Capacity: 100})
+	s.balancer = balancer.NewLoadBalancer([]string{backend.URL}, balancer.NewStrategy(balancer.RoundRobinStrategy), nil)
+	trusted, _ := utils.ParseTrustedProxies([]string{"10.0.0.1"})
+	s.SetTrustedProxies(trusted)
+
+	for _, c := range []struct {
+		remoteAddr, cost string
+		want             string
+		forwarded        string
+	}{
+		// An untrusted client cannot undercut the route, and its header
+		// does not reach the backend.
+		{"192.0.2.1:1000", "1", "5", ""},
+		{"192.0.2.2:1000", "50", "5", ""},
+		// A trusted upstream may raise the cost but not lower it.
+		{"10.0.0.1:1000", "1", "5", "1"},
+		{"10.0.0.1:1000", "50", "50", "50"},
+	} {
+		seen = nil
+		w := s.do("GET", "/reports", c.remoteAddr, http.Header{"X-Cost": {c.cost}}, nil)
+		if got := w.Header().Get("X-RateLimit-Cost"); got != c.want {
+			t.Errorf("%s with cost %s charged %s, want %s", c.remoteAddr, c.cost, got, c.want)
+		}
+		if len(seen) != 1 || seen[0] != c.forwarded {
+			t.Errorf("%s with cost %s forwarded cost header %q, want %q", c.remoteAddr, c.cost, seen, c.forwarded)
+		}
+	}
+}
+
+// ctxBanStorage fails once the caller's context is done, as a database
+// driver would.
+type ctxBanStorage struct{}
+
+func (ctxBanStorage) SaveBan(ctx context.Context, ban *ratelimiter.Ban) error { return ctx.Err() }
+func (ctxBanStorage) DeleteBan(ctx context.Context, clientID string) error    { return ctx.Err() }
+func (ctxBanStorage) GetActiveBans(ctx context.Context) ([]*ratelimiter.Ban, error) {
+	return nil, ctx.Err()
+}
+
+func TestLiftBanTimeout(t *testing.T) {
+	s := newTestServer(t, nil)
+	s.bans = ratelimiter.NewBanTracker(&ratelimiter.BanConfig{Threshold: 1}, ctxBanStorage{})
+	t.Cleanup(s.bans.Stop)
+	s.bans.RecordRejection("192.0.2.1", time.Now())
+
+	ctx, cancel := context.WithDeadline(context.Background(), time.Now())
+	defer cancel()
+	r := httptest.NewRequest("DELETE", "/api/bans?client_id=192.0.2.1", nil).WithContext(ctx)
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, r)
+	if w.Code != http.StatusGatewayTimeout {
+		t.Fatalf("lifting a ban past the request deadline got %d, want 504", w.Code)
+	}
+}
+
+func TestAllowlistedClientsAreNotShed(t *testing.T) {
+	s := newTestServer(t, nil)
+	newACL(t, s, &ratelimiter.ACLEntry{CIDR: "192.0.2.10/32", Action: ratelimiter.ACLAllow})
+	s.shedder = adaptive.NewShedder(&adaptive.ShedderConfig{MaxInFlight: 1, Interval: time.Millisecond}, nil)
+	s.shedder.Start()
+	t.Cleanup(s.shedder.Stop)
+
+	// One request in flight saturates the shedder.
+	done := s.shedder.Track()
+	defer done()
+	for deadline := time.Now().Add(time.Second); s.shedder.Level() == 0; time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("shedder did not start shedding")
+		}
+	}
+
+	if w := s.do("GET", "/", "203.0.113.7:1000", nil, nil); w.Code != http.StatusServiceUnavailable {
+		t.Fatalf("request from an unknown client under overload got %d, want 503", w.Code)
+	}
+	if w := s.do("GET", "/", "192.0.2.10:1000", nil, nil); w.Code != http.StatusOK {
+		t.Fatalf("request from an allowlisted client under overload got %d, want 200", w.Code)
+	}
+}
@@ -1,7 +1,9 @@
-"CREATE TABLE IF NOT EXISTS clients (
+CREATE TABLE IF NOT EXISTS clients (
     client_id TEXT PRIMARY KEY,
     capacity INTEGER NOT NULL,
     rate_per_sec INTEGER NOT NULL,
     created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
     updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
-);"
\ No newline at end of file
+);
+
+CREATE INDEX IF NOT EXISTS idx_clients_updated ON clients(updated_at);
@@ -0,0 +1,12 @@
+ALTER TABLE clients
+    DROP COLUMN IF EXISTS algorithm,
+    DROP COLUMN IF EXISTS daily_quota,
+    DROP COLUMN IF EXISTS monthly_quota,
+    DROP COLUMN IF EXISTS quota_reset_day,
+    DROP COLUMN IF EXISTS plan,
+    DROP COLUMN IF EXISTS routes,
+    DROP COLUMN IF EXISTS max_concurrent,
+    DROP COLUMN IF EXISTS queue_depth,
+    DROP COLUMN IF EXISTS max_wait_ms,
+    DROP COLUMN IF EXISTS priority,
+    DROP COLUMN IF EXISTS shared_bucket;
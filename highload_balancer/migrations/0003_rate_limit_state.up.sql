@@ -0,0 +1,7 @@
+CREATE TABLE IF NOT EXISTS rate_limit_state (
+    key TEXT PRIMARY KEY,
+    capacity DOUBLE PRECISION NOT NULL,
+    rate DOUBLE PRECISION NOT NULL,
+    tokens DOUBLE PRECISION NOT NULL,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
@@ -0,0 +1,10 @@
+CREATE TABLE IF NOT EXISTS plans (
+    name TEXT PRIMARY KEY,
+    capacity INTEGER NOT NULL,
+    rate_per_sec INTEGER NOT NULL,
+    algorithm TEXT NOT NULL DEFAULT '',
+    daily_quota BIGINT NOT NULL DEFAULT 0,
+    monthly_quota BIGINT NOT NULL DEFAULT 0,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
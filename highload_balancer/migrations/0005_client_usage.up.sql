@@ -0,0 +1,7 @@
+CREATE TABLE IF NOT EXISTS client_usage (
+    client_id TEXT NOT NULL,
+    period TEXT NOT NULL,
+    period_start TIMESTAMP WITH TIME ZONE NOT NULL,
+    count BIGINT NOT NULL DEFAULT 0,
+    PRIMARY KEY (client_id, period, period_start)
+);
@@ -0,0 +1 @@
+DROP TABLE IF EXISTS acl_entries;
@@ -0,0 +1,7 @@
+CREATE TABLE IF NOT EXISTS acl_entries (
+    cidr TEXT PRIMARY KEY,
+    action TEXT NOT NULL,
+    comment TEXT NOT NULL DEFAULT '',
+    expires_at TIMESTAMP WITH TIME ZONE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
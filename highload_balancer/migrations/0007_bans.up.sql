@@ -0,0 +1,6 @@
+CREATE TABLE IF NOT EXISTS bans (
+    client_id TEXT PRIMARY KEY,
+    offenses INTEGER NOT NULL,
+    banned_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    banned_until TIMESTAMP WITH TIME ZONE NOT NULL
+);
@@ -0,0 +1,15 @@
+CREATE OR REPLACE FUNCTION notify_clients_changed() RETURNS trigger AS $$
+BEGIN
+    IF TG_OP = 'DELETE' THEN
+        PERFORM pg_notify('clients_changed', OLD.client_id);
+    ELSE
+        PERFORM pg_notify('clients_changed', NEW.client_id);
+    END IF;
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS clients_changed ON clients;
+CREATE TRIGGER clients_changed
+AFTER INSERT OR UPDATE OR DELETE ON clients
+FOR EACH ROW EXECUTE FUNCTION notify_clients_changed();
@@ -0,0 +1,9 @@
+// Package migrations holds the numbered PostgreSQL schema migrations.
+// Each version has a NNNN_name.up.sql file and a matching .down.sql file
+// that reverts it.
+package migrations
+
+import "embed"
+
+//go:embed *.sql
+var FS embed.FS
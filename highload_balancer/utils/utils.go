@@ -2,26 +2,94 @@ package utils
 
 import (
 	"encoding/json"
+	"fmt"
 	"net"
 	"net/http"
+	"net/netip"
 	"strings"
 )
 
-func GetClientIP(r *http.Request) string {
-	if xff := r.Header.Get("X-Forwarded-For"); xff != "" {
-		ips := strings.Split(xff, ",")
-		return strings.TrimSpace(ips[0])
+// ParseTrustedProxies parses the addresses and CIDRs of the proxies whose
+// forwarding headers are believed.
+func ParseTrustedProxies(entries []string) ([]netip.Prefix, error) {
+	prefixes := make([]netip.Prefix, 0, len(entries))
+	for _, entry := range entries {
+		if !strings.Contains(entry, "/") {
+			addr, err := netip.ParseAddr(entry)
+			if err != nil {
+				return nil, fmt.Errorf("trusted proxy %q: %w", entry, err)
+			}
+			addr = addr.Unmap()
+			prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+			continue
+		}
+		prefix, err := netip.ParsePrefix(entry)
+		if err != nil {
+			return nil, fmt.Errorf("trusted proxy %q: %w", entry, err)
+		}
+		prefixes = append(prefixes, prefix.Masked())
 	}
+	return prefixes, nil
+}
 
-	if xri := r.Header.Get("X-Real-Ip"); xri != "" {
+// ClientIP returns the address the request came from. X-Forwarded-For
+// and X-Real-Ip are only believed when the peer is a trusted proxy; the
+// client is then the rightmost forwarded hop that is not one.
+func ClientIP(r *http.Request, trusted []netip.Prefix) string {
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		ip = r.RemoteAddr
+	}
+	if !isTrusted(ip, trusted) {
+		return ip
+	}
+
+	if xff := r.Header.Values("X-Forwarded-For"); len(xff) > 0 {
+		hops := strings.Split(strings.Join(xff, ","), ",")
+		for i := len(hops) - 1; i >= 0; i-- {
+			hop := strings.TrimSpace(hops[i])
+			if hop == "" {
+				continue
+			}
+			ip = hop
+			if !isTrusted(hop, trusted) {
+				break
+			}
+		}
+		return ip
+	}
+
+	if xri := strings.TrimSpace(r.Header.Get("X-Real-Ip")); xri != "" {
 		return xri
 	}
+	return ip
+}
 
+// IsTrustedProxy reports whether the request came straight from a
+// trusted proxy.
+func IsTrustedProxy(r *http.Request, trusted []netip.Prefix) bool {
 	ip, _, err := net.SplitHostPort(r.RemoteAddr)
 	if err != nil {
-		return r.RemoteAddr
+		ip = r.RemoteAddr
 	}
-	return ip
+	return isTrusted(ip, trusted)
+}
+
+func isTrusted(ip string, trusted []netip.Prefix) bool {
+	if len(trusted) == 0 {
+		return false
+	}
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+	for _, prefix := range trusted {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
 }
 
 func IsHealthCheckRequest(r *http.Request) bool {